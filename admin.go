@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// serveAdmin serves the admin API on addr.
+func (d *webdavfsDriver) serveAdmin(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/volumes/", d.adminVolume)
+	mux.HandleFunc("/export", d.adminExport)
+	mux.HandleFunc("/import", d.adminImport)
+	mux.HandleFunc("/metrics", d.serveMetrics)
+	mux.HandleFunc("/health", d.serveHealth)
+
+	l, err := listen(addr)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	logrus.Infof("admin API listening on %s", addr)
+	logrus.Error(http.Serve(l, mux))
+}
+
+// adminVolume handles POST /volumes/<name>/<action>.
+func (d *webdavfsDriver) adminVolume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/volumes/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	name, action := parts[0], parts[1]
+
+	d.Lock()
+	defer d.Unlock()
+
+	if action == "restore" {
+		// undoes removing the volume within REMOVE_RETENTION
+		err := d.restoreVolume(name)
+		d.audit.record(action, name, err, map[string]interface{}{"remote": r.RemoteAddr})
+		if _, ok := d.trash[name]; ok && err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	v, ok := d.volumes[name]
+	if !ok {
+		http.Error(w, "volume not found", http.StatusNotFound)
+		return
+	}
+	switch action {
+	case "allow-remove":
+		// allows removing a protected volume until the plugin restarts
+		logrus.WithField("volume", name).Info("removing protected volume allowed")
+		v.allowRemove = true
+		d.audit.record(action, name, nil, map[string]interface{}{"remote": r.RemoteAddr})
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
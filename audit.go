@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditLog is an append-only journal of the operations on volumes.
+type auditLog struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// openAuditLog opens the audit log at path, or the local syslog for
+// "syslog".
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "syslog" {
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "webdavfs")
+		if err != nil {
+			return nil, err
+		}
+		return &auditLog{w: w}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{w: f}, nil
+}
+
+// record writes an entry for action on the volume, a nil audit log records
+// nothing.
+func (a *auditLog) record(action, name string, err error, fields map[string]interface{}) {
+	if a == nil {
+		return
+	}
+	entry := map[string]interface{}{
+		"time":   time.Now().UTC().Format(time.RFC3339),
+		"action": action,
+		"volume": name,
+	}
+	for key, val := range fields {
+		entry[key] = val
+	}
+	if err != nil {
+		entry["error"] = err.Error()
+	}
+	data, _ := json.Marshal(entry)
+
+	a.Lock()
+	defer a.Unlock()
+	a.w.Write(append(data, '\n'))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// authSchemes returns the authentication schemes the server offers for
+// target, lower cased, by sending it an unauthenticated OPTIONS request.
+func authSchemes(v *webdavfsVolume, target string) ([]string, error) {
+	req, err := http.NewRequest("OPTIONS", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newClient(v)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	var schemes []string
+	for _, challenge := range resp.Header["Www-Authenticate"] {
+		scheme := strings.Fields(challenge)
+		if len(scheme) > 0 {
+			schemes = append(schemes, strings.ToLower(scheme[0]))
+		}
+	}
+	return schemes, nil
+}
+
+// checkAuthScheme makes sure the server offers the authentication scheme
+// required by the volume, so the mount helper doesn't fall back to Basic and
+// send the password in clear text.
+func checkAuthScheme(v *webdavfsVolume, target string) error {
+	if v.Auth != "digest" && v.Auth != "ntlm" {
+		return nil
+	}
+
+	schemes, err := authSchemes(v, target)
+	if err != nil {
+		return err
+	}
+	for _, scheme := range schemes {
+		if scheme == v.Auth {
+			return nil
+		}
+	}
+	return fmt.Errorf("server doesn't offer %s authentication (offered: %s)", v.Auth, strings.Join(schemes, ", "))
+}
+
+// checkAnonymous makes sure an anonymous volume doesn't need credentials,
+// instead of the mount helper waiting for them to be entered.
+func checkAnonymous(v *webdavfsVolume, target string) error {
+	if !v.Anonymous {
+		return nil
+	}
+
+	resp, err := davRequest(v, "PROPFIND", target, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("server requires authentication but the volume is anonymous")
+	}
+	return nil
+}
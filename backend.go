@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultBackend = "davfs2"
+
+// mounter mounts and unmounts volumes with a particular mount helper.
+type mounter interface {
+	// Mount mounts the WebDAV collection at target on dir, u is the url
+	// the volume is mounted from.
+	Mount(ctx context.Context, v *webdavfsVolume, u *url.URL, target, dir string) error
+	// Unmount unmounts dir.
+	Unmount(v *webdavfsVolume, dir string) error
+}
+
+func (v *webdavfsVolume) backend() string {
+	if v.Mode == "sync" {
+		return "sync"
+	}
+	if v.Backend == "" {
+		return defaultBackend
+	}
+	return v.Backend
+}
+
+// checkBackendOptions makes sure the backend of the volume supports its
+// options, instead of mounting without them.
+func checkBackendOptions(v *webdavfsVolume) error {
+	var unsupported []string
+	if v.backend() != "davfs2" {
+		unsupported = append(unsupported, davfs2Options(v)...)
+	}
+	switch v.backend() {
+	case "fuse-webdavfs":
+		// credentials, proxy and TLS options are handled by the davProxy
+		if v.Headers != "" {
+			unsupported = append(unsupported, "headers")
+		}
+		if v.Krb5 {
+			unsupported = append(unsupported, "krb5")
+		}
+		if v.Auth != "" {
+			unsupported = append(unsupported, "auth")
+		}
+	case "rclone", "sync":
+		if v.Insecure {
+			unsupported = append(unsupported, "insecure")
+		}
+		if v.CACert != "" {
+			unsupported = append(unsupported, "ca_cert")
+		}
+		if v.ClientCert != "" || v.ClientKey != "" {
+			unsupported = append(unsupported, "client_cert")
+		}
+		if v.Headers != "" {
+			unsupported = append(unsupported, "headers")
+		}
+		if v.Proxy != "" && !isSocksProxy(v.Proxy) {
+			unsupported = append(unsupported, "proxy")
+		}
+		if v.Auth == "sharepoint" || v.Auth == "ntlm" {
+			unsupported = append(unsupported, "auth")
+		}
+		if v.Krb5 {
+			unsupported = append(unsupported, "krb5")
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("'%s' option not supported by backend %s", unsupported[0], v.backend())
+	}
+	return nil
+}
+
+// davfs2Options returns the options of the volume only davfs2 supports.
+func davfs2Options(v *webdavfsVolume) []string {
+	var options []string
+	if v.Conf != "" {
+		options = append(options, "conf")
+	}
+	if v.ConfContent != "" {
+		options = append(options, "conf_content")
+	}
+	if v.UseLocks != nil {
+		options = append(options, "use_locks")
+	}
+	if v.IfMatchBug != nil {
+		options = append(options, "if_match_bug")
+	}
+	if v.IgnoreDAVHeader != nil {
+		options = append(options, "ignore_dav_header")
+	}
+	if v.DropWeakEtags != nil {
+		options = append(options, "drop_weak_etags")
+	}
+	if v.UseCompression != nil {
+		options = append(options, "use_compression")
+	}
+	if v.ServerCharset != "" {
+		options = append(options, "server_charset")
+	}
+	if v.CacheSize > 0 {
+		options = append(options, "cache_size")
+	}
+	if v.FileRefresh > 0 {
+		options = append(options, "file_refresh")
+	}
+	if v.ConnectTimeout > 0 {
+		options = append(options, "connect_timeout")
+	}
+	if v.ReadTimeout > 0 {
+		options = append(options, "read_timeout")
+	}
+	return options
+}
+
+// helperCommand returns the command running a mount helper, in a private
+// mount namespace when MOUNT_NAMESPACE is set. The namespace keeps the
+// propagation of the driver namespace, so only mounts on the shared volumes
+// root show up on the host and anything else the helper mounts goes away
+// with it.
+func (d *webdavfsDriver) helperCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if !d.mountNamespace {
+		return exec.CommandContext(ctx, name, args...)
+	}
+	args = append([]string{"--mount", "--propagation", "unchanged", name}, args...)
+	return exec.CommandContext(ctx, "unshare", args...)
+}
+
+// shareRoot makes the volumes root a shared mount, so mounts made in helper
+// namespaces propagate back to the driver.
+func (d *webdavfsDriver) shareRoot() error {
+	out, err := exec.Command("mount", "--make-rshared", d.root).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mount --make-rshared %s: %v: %s", d.root, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// cacheDir returns the local cache directory of the volume.
+func (d *webdavfsDriver) cacheDir(v *webdavfsVolume) string {
+	root := d.cachePath
+	if v.CacheDir != "" {
+		root = v.CacheDir
+	}
+	return filepath.Join(root, filepath.Base(v.Mountpoint))
+}
+
+// cacheDirs returns the local cache directories of the volume.
+func (d *webdavfsDriver) cacheDirs(v *webdavfsVolume) []string {
+	dirs := []string{d.cacheDir(v)}
+	// davfs2 names its cache directories after the mountpoint
+	for _, dir := range []string{davfs2CacheDir, v.CacheDir} {
+		if dir != "" {
+			matches, _ := filepath.Glob(filepath.Join(dir, "*"+filepath.Base(v.Mountpoint)+"*"))
+			dirs = append(dirs, matches...)
+		}
+	}
+	return dirs
+}
+
+// cacheUsage returns the bytes the local cache directories take on disk.
+func cacheUsage(dirs []string) int64 {
+	var n int64
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			// allocated blocks, the VFS cache of rclone is sparse
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				n += st.Blocks * 512
+			} else {
+				n += fi.Size()
+			}
+			return nil
+		})
+	}
+	return n
+}
+
+// mountPoints returns the mount points of the mount namespace of the driver.
+func mountPoints() ([]string, error) {
+	data, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	unescape := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) > 4 {
+			dirs = append(dirs, unescape.Replace(fields[4]))
+		}
+	}
+	return dirs, nil
+}
+
+// diskUsage returns the size, used and available bytes of the filesystem
+// mounted on dir, giving up after timeout as a hung mount blocks.
+func diskUsage(dir string, timeout time.Duration) (size, used, free uint64, err error) {
+	done := make(chan error, 1)
+	var st syscall.Statfs_t
+	go func() {
+		done <- syscall.Statfs(dir, &st)
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		return 0, 0, 0, fmt.Errorf("statfs %s timed out", dir)
+	}
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	bsize := uint64(st.Bsize)
+	return st.Blocks * bsize, (st.Blocks - st.Bfree) * bsize, st.Bavail * bsize, nil
+}
+
+// isMounted reports whether something is mounted on dir.
+func isMounted(dir string) bool {
+	dirs, _ := mountPoints()
+	for _, mounted := range dirs {
+		if mounted == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func umount(target string) error {
+	cmd := fmt.Sprintf("umount %s", target)
+	logrus.Debug(cmd)
+	if out, err := exec.Command("sh", "-c", cmd).CombinedOutput(); err != nil {
+		return helperError("umount", err, out)
+	}
+	return nil
+}
+
+// runHelper runs a mount helper, its output is collected in a file instead
+// of a pipe since the daemonized helper would keep a pipe open.
+func runHelper(name string, cmd *exec.Cmd) error {
+	f, err := ioutil.TempFile("", name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	cmd.Stdout, cmd.Stderr = f, f
+	if err := cmd.Run(); err != nil {
+		out, _ := ioutil.ReadFile(f.Name())
+		return helperError(name, err, out)
+	}
+	return nil
+}
+
+var (
+	userinfoRe    = regexp.MustCompile(`://[^/@\s]+@`)
+	passwordArgRe = regexp.MustCompile(`password=\S+`)
+)
+
+// helperError returns the error of a failed mount helper including its
+// output, without credentials and shortened to the last KiB, so users see
+// why mounting failed.
+func helperError(name string, err error, out []byte) error {
+	msg := strings.TrimSpace(string(out))
+	msg = userinfoRe.ReplaceAllString(msg, "://"+redacted+"@")
+	msg = passwordArgRe.ReplaceAllString(msg, "password="+redacted)
+	if len(msg) > 1024 {
+		msg = "..." + msg[len(msg)-1024:]
+	}
+	if msg == "" {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return fmt.Errorf("%s: %v: %s", name, err, msg)
+}
+
+// redactArgs returns the mount helper arguments with passwords hidden.
+func redactArgs(args []string) []string {
+	safe := make([]string, len(args))
+	for i, arg := range args {
+		// also within the o option
+		safe[i] = passwordArgRe.ReplaceAllString(arg, "password="+redacted)
+	}
+	return safe
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// davfs2CacheDir is where davfs2 caches files by default.
+const davfs2CacheDir = "/var/cache/davfs2"
+
+// davfs2Mounter mounts volumes with davfs2, which is configured through a
+// generated per-volume configuration file.
+type davfs2Mounter struct {
+	d *webdavfsDriver
+}
+
+func (m *davfs2Mounter) Mount(ctx context.Context, v *webdavfsVolume, u *url.URL, target, dir string) error {
+	d := m.d
+	cmd := d.helperCommand(ctx, "mount.webdavfs", target, dir)
+	if v.Krb5 {
+		cmd.Env = append(os.Environ(), "KRB5CCNAME="+d.krb5CCache(v))
+	}
+
+	conf, err := d.writeConf(v)
+	if err != nil {
+		return err
+	}
+	if conf != "" {
+		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("conf=%s", conf))
+	}
+	if v.UID != "" {
+		exec.Command("adduser", "-S", "-u", v.UID, v.UID).Run()
+		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("uid=%s", v.UID))
+	}
+	if v.GID != "" {
+		exec.Command("addgroup", "-S", "-g", v.GID, v.GID).Run()
+		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("gid=%s", v.GID))
+	}
+	if v.FileMode != "" {
+		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("file_mode=%s", v.FileMode))
+	}
+	if v.DirMode != "" {
+		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("dir_mode=%s", v.DirMode))
+	}
+	if v.Ro {
+		cmd.Args = append(cmd.Args, "-o", "ro")
+	}
+	if v.Rw {
+		cmd.Args = append(cmd.Args, "-o", "rw")
+	}
+	if v.Exec {
+		cmd.Args = append(cmd.Args, "-o", "exec")
+	}
+	if v.Suid {
+		cmd.Args = append(cmd.Args, "-o", "suid")
+	}
+	if v.Grpid {
+		cmd.Args = append(cmd.Args, "-o", "grpid")
+	}
+	if v.Netdev {
+		cmd.Args = append(cmd.Args, "-o", "_netdev")
+	}
+	if v.Atime != "" {
+		cmd.Args = append(cmd.Args, "-o", v.Atime)
+	}
+	if v.Options != "" {
+		cmd.Args = append(cmd.Args, "-o", v.Options)
+	}
+
+	if username, password := credentials(v, u); username != "" && (v.Auth != "sharepoint" || v.proxy != nil) {
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("%s\n%s", username, password))
+	}
+
+	logrus.Debug(cmd.Args)
+	return runHelper("mount.webdavfs", cmd)
+}
+
+func (m *davfs2Mounter) Unmount(v *webdavfsVolume, dir string) error {
+	return umount(dir)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// dryrunMounter only logs mount and unmount calls instead of executing them,
+// so the driver can be exercised without a WebDAV server or FUSE.
+type dryrunMounter struct{}
+
+func (m *dryrunMounter) Mount(ctx context.Context, v *webdavfsVolume, u *url.URL, target, dir string) error {
+	logrus.WithField("method", "dryrun").Infof("mount %s %s", redactURL(target), dir)
+	return nil
+}
+
+func (m *dryrunMounter) Unmount(v *webdavfsVolume, dir string) error {
+	logrus.WithField("method", "dryrun").Infof("umount %s", dir)
+	return nil
+}
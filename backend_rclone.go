@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// rcloneMounter mounts volumes with rclone, whose VFS cache and chunked
+// transfers cope better with large files and flaky servers.
+type rcloneMounter struct {
+	d *webdavfsDriver
+}
+
+// rcloneObscure obscures a password the way rclone expects it. The password
+// is passed on stdin, any process can read the command line.
+func rcloneObscure(password string) (string, error) {
+	cmd := exec.Command("rclone", "obscure", "-")
+	cmd.Stdin = strings.NewReader(password)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("rclone obscure: %v", err)
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// rcloneEnv returns the environment configuring the :webdav: remote of
+// rclone for the volume.
+func rcloneEnv(v *webdavfsVolume, u *url.URL, target string) ([]string, error) {
+	env := append(os.Environ(), "RCLONE_WEBDAV_URL="+target)
+
+	if username, password := credentials(v, u); username != "" {
+		obscured, err := rcloneObscure(password)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, "RCLONE_WEBDAV_USER="+username, "RCLONE_WEBDAV_PASS="+obscured)
+	}
+	// the davProxy sends the token instead
+	if v.token != "" && v.proxy == nil {
+		env = append(env, "RCLONE_WEBDAV_BEARER_TOKEN="+v.token)
+	}
+	if v.Nextcloud {
+		env = append(env, "RCLONE_WEBDAV_VENDOR=nextcloud")
+	}
+	if v.ChunkSize > 0 {
+		// uploads larger than this use nextcloud chunked uploads
+		env = append(env, fmt.Sprintf("RCLONE_WEBDAV_NEXTCLOUD_CHUNK_SIZE=%dB", v.ChunkSize))
+	}
+
+	if v.Encrypt {
+		password, err := resolveSecret(v.EncryptPassword)
+		if err != nil {
+			return nil, err
+		}
+		obscured, err := rcloneObscure(password)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, "RCLONE_CRYPT_REMOTE=:webdav:", "RCLONE_CRYPT_PASSWORD="+obscured)
+	}
+	if v.Compress {
+		// compress before encrypting, encrypted data doesn't compress
+		remote := ":webdav:"
+		if v.Encrypt {
+			remote = ":crypt:"
+		}
+		env = append(env, "RCLONE_COMPRESS_REMOTE="+remote)
+	}
+	return env, nil
+}
+
+// rcloneRemote returns the rclone remote of the volume, which compresses
+// and/or encrypts file contents when enabled.
+func rcloneRemote(v *webdavfsVolume) string {
+	switch {
+	case v.Compress:
+		return ":compress:"
+	case v.Encrypt:
+		return ":crypt:"
+	default:
+		return ":webdav:"
+	}
+}
+
+func (m *rcloneMounter) Mount(ctx context.Context, v *webdavfsVolume, u *url.URL, target, dir string) error {
+	env, err := rcloneEnv(v, u, target)
+	if err != nil {
+		return err
+	}
+	cmd := m.d.helperCommand(ctx, "rclone", "mount", rcloneRemote(v), dir, "--daemon")
+	cmd.Env = env
+
+	cmd.Args = append(cmd.Args, "--cache-dir", m.d.cacheDir(v))
+	if v.VFSCacheMode != "" {
+		cmd.Args = append(cmd.Args, "--vfs-cache-mode", v.VFSCacheMode)
+	}
+	if v.VFSCacheMaxSize != "" {
+		cmd.Args = append(cmd.Args, "--vfs-cache-max-size", v.VFSCacheMaxSize)
+	}
+	if v.Transfers > 0 {
+		cmd.Args = append(cmd.Args, "--transfers", strconv.Itoa(v.Transfers))
+	}
+	if v.UID != "" {
+		cmd.Args = append(cmd.Args, "--uid", v.UID)
+	}
+	if v.GID != "" {
+		cmd.Args = append(cmd.Args, "--gid", v.GID)
+	}
+	if v.BufSize > 0 {
+		cmd.Args = append(cmd.Args, "--buffer-size", fmt.Sprintf("%dK", v.BufSize))
+	}
+	if v.NoCache {
+		cmd.Args = append(cmd.Args, "--vfs-cache-mode", "off", "--dir-cache-time", "0s", "--attr-timeout", "0s")
+	}
+	if v.DirRefresh > 0 {
+		cmd.Args = append(cmd.Args, "--dir-cache-time", fmt.Sprintf("%ds", v.DirRefresh))
+	}
+	if v.DelayUpload != nil {
+		cmd.Args = append(cmd.Args, "--vfs-write-back", fmt.Sprintf("%ds", *v.DelayUpload))
+	}
+	if v.Ro {
+		cmd.Args = append(cmd.Args, "--read-only")
+	}
+	if v.Umask != "" {
+		cmd.Args = append(cmd.Args, "--umask", v.Umask)
+	}
+	if v.Atime != "" {
+		cmd.Args = append(cmd.Args, "-o", v.Atime)
+	}
+	if v.AllowOther {
+		cmd.Args = append(cmd.Args, "--allow-other")
+	}
+	if v.AllowRoot {
+		cmd.Args = append(cmd.Args, "--allow-root")
+	}
+	if v.Options != "" {
+		// rclone takes a single FUSE option per flag
+		for _, opt := range strings.Split(v.Options, ",") {
+			cmd.Args = append(cmd.Args, "-o", opt)
+		}
+	}
+
+	logrus.Debug(cmd.Args)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return helperError("rclone", err, out)
+	}
+	return nil
+}
+
+func (m *rcloneMounter) Unmount(v *webdavfsVolume, dir string) error {
+	return umount(dir)
+}
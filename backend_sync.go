@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// syncMounter doesn't mount anything, the volume mountpoint is a local
+// directory which is periodically synchronised with the server using rclone.
+// Containers get local filesystem semantics and survive server outages.
+type syncMounter struct {
+	sync.Mutex
+
+	d *webdavfsDriver
+
+	// volumes being synchronised, by directory
+	volumes map[string]*syncVolume
+}
+
+type syncVolume struct {
+	sync.Mutex
+
+	v       *webdavfsVolume
+	dir     string
+	env     []string
+	remote  string
+	workdir string
+	done    chan struct{}
+}
+
+// conflictsReport is the file in two-way synchronised volumes listing the
+// conflicting copies of files changed on both sides.
+const conflictsReport = ".webdavfs-conflicts"
+
+// syncUploadTimeout is how long the last upload when unmounting may take.
+const syncUploadTimeout = 10 * time.Minute
+
+func newSyncMounter(d *webdavfsDriver) *syncMounter {
+	return &syncMounter{d: d, volumes: map[string]*syncVolume{}}
+}
+
+// run synchronises the volume once in the configured direction.
+func (s *syncVolume) run(ctx context.Context, direction string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var args []string
+	switch direction {
+	case "upload":
+		args = []string{"sync", s.dir, s.remote}
+	case "both":
+		// files changed on both sides are kept as numbered conflict copies
+		args = []string{"bisync", s.remote, s.dir, "--workdir", s.workdir,
+			"--conflict-resolve", "none", "--conflict-loser", "num",
+			"--exclude", "/" + conflictsReport}
+		// bisync starts over without the listings of a previous run
+		if listings, _ := filepath.Glob(filepath.Join(s.workdir, "*.lst")); len(listings) == 0 {
+			args = append(args, "--resync")
+		}
+	default:
+		args = []string{"sync", s.remote, s.dir}
+	}
+	cmd := exec.CommandContext(ctx, "rclone", args...)
+	cmd.Env = s.env
+
+	logrus.WithField("method", "sync").Debug(cmd.Args)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rclone: %v: %s", err, bytes.TrimSpace(out))
+	}
+	if direction == "both" {
+		return s.writeConflictsReport()
+	}
+	return nil
+}
+
+// writeConflictsReport lists the conflict copies in the volume.
+func (s *syncVolume) writeConflictsReport() error {
+	var conflicts []string
+	err := filepath.Walk(s.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.Contains(fi.Name(), ".conflict") {
+			rel, _ := filepath.Rel(s.dir, path)
+			conflicts = append(conflicts, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, conflictsReport)
+	if len(conflicts) == 0 {
+		os.Remove(path)
+		return nil
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(conflicts, "\n")+"\n"), 0644)
+}
+
+func (s *syncVolume) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.run(context.Background(), s.v.syncDirection()); err != nil {
+				logrus.WithField("method", "sync").Error(err)
+			}
+		}
+	}
+}
+
+func (m *syncMounter) Mount(ctx context.Context, v *webdavfsVolume, u *url.URL, target, dir string) error {
+	env, err := rcloneEnv(v, u, target)
+	if err != nil {
+		return err
+	}
+	s := &syncVolume{
+		v:       v,
+		dir:     dir,
+		env:     env,
+		remote:  rcloneRemote(v),
+		workdir: filepath.Join(m.d.cacheDir(v), "bisync"),
+		done:    make(chan struct{}),
+	}
+
+	// start with the content of the server, upload volumes with what is left
+	// to upload, e.g. after a crash. Synchronising a large volume takes
+	// longer than the mount timeout and Docker requests are served meanwhile,
+	// acquireMount waits for the volume.
+	m.d.Unlock()
+	err = s.run(context.Background(), v.syncDirection())
+	m.d.Lock()
+	if err != nil {
+		return err
+	}
+
+	interval := time.Minute
+	if v.SyncInterval > 0 {
+		interval = time.Duration(v.SyncInterval) * time.Second
+	}
+	go s.loop(interval)
+
+	m.Lock()
+	m.volumes[dir] = s
+	m.Unlock()
+	return nil
+}
+
+func (m *syncMounter) Unmount(v *webdavfsVolume, dir string) error {
+	m.Lock()
+	s, ok := m.volumes[dir]
+	delete(m.volumes, dir)
+	m.Unlock()
+	if !ok {
+		return nil
+	}
+
+	close(s.done)
+	if direction := v.syncDirection(); direction != "download" {
+		// what isn't uploaded in time is uploaded by the next mount
+		ctx, cancel := context.WithTimeout(context.Background(), syncUploadTimeout)
+		defer cancel()
+		m.d.Unlock()
+		defer m.d.Lock()
+		return s.run(ctx, direction)
+	}
+	return nil
+}
+
+func (v *webdavfsVolume) syncDirection() string {
+	if v.SyncDirection == "" {
+		return "download"
+	}
+	return v.SyncDirection
+}
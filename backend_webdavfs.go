@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// webdavfsMounter mounts volumes with the webdavfs FUSE filesystem
+// (github.com/miquels/webdavfs).
+type webdavfsMounter struct {
+	d *webdavfsDriver
+}
+
+func (m *webdavfsMounter) Mount(ctx context.Context, v *webdavfsVolume, u *url.URL, target, dir string) error {
+	cmd := m.d.helperCommand(ctx, "webdavfs", target, dir)
+
+	// the volume is mounted through the davProxy, these are its per-mount
+	// credentials rather than those of the server
+	if username, password := credentials(v, u); username != "" {
+		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("username=%s", username))
+		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("password=%s", password))
+	}
+	if v.UID != "" {
+		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("uid=%s", v.UID))
+	}
+	if v.GID != "" {
+		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("gid=%s", v.GID))
+	}
+	if v.FileMode != "" {
+		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("mode=%s", v.FileMode))
+	}
+	if v.Ro {
+		cmd.Args = append(cmd.Args, "-o", "ro")
+	}
+	if v.Atime != "" {
+		cmd.Args = append(cmd.Args, "-o", v.Atime)
+	}
+	if v.AllowOther {
+		cmd.Args = append(cmd.Args, "-o", "allow_other")
+	}
+	if v.AllowRoot {
+		cmd.Args = append(cmd.Args, "-o", "allow_root")
+	}
+	if v.Options != "" {
+		cmd.Args = append(cmd.Args, "-o", v.Options)
+	}
+
+	logrus.Debug(redactArgs(cmd.Args))
+	return runHelper("webdavfs", cmd)
+}
+
+func (m *webdavfsMounter) Unmount(v *webdavfsVolume, dir string) error {
+	return umount(dir)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// mountBreaker stops mounting from urls which failed too often in a row for
+// a while, so a flapping server doesn't pile up hanging mount helpers.
+type mountBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  map[string]int
+	until     map[string]time.Time
+}
+
+func newMountBreaker(threshold int, cooldown time.Duration) *mountBreaker {
+	return &mountBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		failures:  map[string]int{},
+		until:     map[string]time.Time{},
+	}
+}
+
+// check returns an error while mounting from rawurl is suspended.
+func (b *mountBreaker) check(rawurl string) error {
+	until, ok := b.until[rawurl]
+	if !ok || time.Now().After(until) {
+		return nil
+	}
+
+	host := rawurl
+	if u, err := url.Parse(rawurl); err == nil {
+		host = u.Host
+	}
+	return fmt.Errorf("mounting from %s failed %d times in a row, not trying again for %v",
+		host, b.failures[rawurl], until.Sub(time.Now()).Round(time.Second))
+}
+
+// record updates the consecutive failures of rawurl with the outcome of a
+// mount.
+func (b *mountBreaker) record(rawurl string, err error) {
+	if err == nil {
+		delete(b.failures, rawurl)
+		delete(b.until, rawurl)
+		return
+	}
+
+	b.failures[rawurl]++
+	if b.threshold > 0 && b.failures[rawurl] >= b.threshold {
+		b.until[rawurl] = time.Now().Add(b.cooldown)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// confDirectives returns the davfs2 configuration directives derived from
+// the volume options, writing the files they refer to.
+func (d *webdavfsDriver) confDirectives(v *webdavfsVolume) ([]string, error) {
+	var lines []string
+	if v.Anonymous && v.proxy == nil {
+		lines = append(lines, "ask_auth 0")
+	}
+	headers, err := parseHeaders(v.Headers)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range headers {
+		lines = append(lines, fmt.Sprintf("add_header %s %s", h[0], confQuote(h[1])))
+	}
+	// the davProxy sends the token and cookies instead
+	if v.token != "" && v.proxy == nil {
+		lines = append(lines, fmt.Sprintf("add_header Authorization %s", confQuote("Bearer "+v.token)))
+	}
+	if v.UseLocks != nil {
+		lines = append(lines, fmt.Sprintf("use_locks %d", confBool(*v.UseLocks)))
+	}
+	if v.IfMatchBug != nil {
+		lines = append(lines, fmt.Sprintf("if_match_bug %d", confBool(*v.IfMatchBug)))
+	}
+	if v.IgnoreDAVHeader != nil {
+		lines = append(lines, fmt.Sprintf("ignore_dav_header %d", confBool(*v.IgnoreDAVHeader)))
+	}
+	if v.DropWeakEtags != nil {
+		lines = append(lines, fmt.Sprintf("drop_weak_etags %d", confBool(*v.DropWeakEtags)))
+	}
+	if v.UseCompression != nil {
+		lines = append(lines, fmt.Sprintf("use_compression %d", confBool(*v.UseCompression)))
+	}
+	if v.ServerCharset != "" {
+		lines = append(lines, fmt.Sprintf("server_charset %s", v.ServerCharset))
+	}
+	if v.CacheSize > 0 {
+		lines = append(lines, fmt.Sprintf("cache_size %d", v.CacheSize))
+	}
+	if v.CacheDir != "" {
+		lines = append(lines, fmt.Sprintf("cache_dir %s", confQuote(v.CacheDir)))
+	}
+	if v.BufSize > 0 {
+		lines = append(lines, fmt.Sprintf("buf_size %d", v.BufSize))
+	}
+	if v.NoCache {
+		// davfs2 always caches, revalidate everything on every access
+		// and upload on close instead
+		lines = append(lines, "dir_refresh 1", "file_refresh 1", "delay_upload 0")
+	}
+	if v.DirRefresh > 0 {
+		lines = append(lines, fmt.Sprintf("dir_refresh %d", v.DirRefresh))
+	}
+	if v.FileRefresh > 0 {
+		lines = append(lines, fmt.Sprintf("file_refresh %d", v.FileRefresh))
+	}
+	if v.DelayUpload != nil {
+		lines = append(lines, fmt.Sprintf("delay_upload %d", *v.DelayUpload))
+	}
+	if v.ConnectTimeout > 0 {
+		lines = append(lines, fmt.Sprintf("connect_timeout %d", v.ConnectTimeout))
+	}
+	if v.ReadTimeout > 0 {
+		lines = append(lines, fmt.Sprintf("read_timeout %d", v.ReadTimeout))
+	}
+	// the davProxy connects through the proxy itself, davfs2 only talks to
+	// the davProxy on the loopback
+	if v.Proxy != "" && !isSocksProxy(v.Proxy) && v.proxy == nil {
+		u, err := proxyURL(v.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, "use_proxy 1", fmt.Sprintf("proxy %s", u.Host))
+	}
+	if v.Auth == "sharepoint" {
+		if v.proxy == nil {
+			cookies, err := sharepointCookies(v)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, fmt.Sprintf("add_header Cookie %s", confQuote(cookies)))
+		}
+		lines = append(lines, "sharepoint_href_bug 1")
+	}
+	if v.ClientCert != "" {
+		path, err := d.writeClientCert(v)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fmt.Sprintf("clientcert %s", path))
+	}
+	if v.CACert != "" {
+		path, err := d.writeCACert(v)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fmt.Sprintf("trust_ca_cert %s", path))
+	}
+	if v.Insecure && strings.HasPrefix(v.url, "https:") {
+		path, err := d.writeServerCert(v)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fmt.Sprintf("trust_server_cert %s", path))
+	}
+	return lines, nil
+}
+
+// writeConf writes a per-volume davfs2 configuration file made of the user
+// supplied conf file and conf content followed by the directives generated
+// from the volume options. It returns the path to pass to the mount helper,
+// which is the user supplied conf file when nothing else needs to be written.
+func (d *webdavfsDriver) writeConf(v *webdavfsVolume) (string, error) {
+	if err := os.MkdirAll(d.confPath, 0700); err != nil {
+		return "", err
+	}
+
+	lines, err := d.confDirectives(v)
+	if err != nil {
+		return "", err
+	}
+	if len(lines) == 0 && v.ConfContent == "" {
+		return v.Conf, nil
+	}
+
+	var buf bytes.Buffer
+	if v.Conf != "" {
+		data, err := ioutil.ReadFile(v.Conf)
+		if err != nil {
+			return "", err
+		}
+		buf.Write(data)
+		buf.WriteString("\n")
+	}
+	if v.ConfContent != "" {
+		buf.WriteString(v.ConfContent)
+		buf.WriteString("\n")
+	}
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+
+	path := filepath.Join(d.confPath, filepath.Base(v.Mountpoint)+".conf")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// parseConfContent returns the conf_content option, either the configuration
+// itself or base64 encoded. Configuration lines always contain whitespace
+// which base64 doesn't.
+func parseConfContent(val string) (string, error) {
+	if strings.ContainsAny(val, " \t\n") {
+		return val, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// confQuote quotes a davfs2 configuration value.
+func confQuote(val string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(val) + `"`
+}
+
+// confBool returns a davfs2 configuration boolean.
+func confBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parseHeaders parses the headers option, "name:value" pairs separated by
+// semicolons.
+func parseHeaders(val string) ([][2]string, error) {
+	var headers [][2]string
+	for _, pair := range strings.Split(val, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, ":", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || name == "" || strings.ContainsAny(name, " \t\"") {
+			return nil, fmt.Errorf("invalid header %q", pair)
+		}
+		headers = append(headers, [2]string{name, strings.TrimSpace(kv[1])})
+	}
+	return headers, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		val  string
+		want [][2]string
+		ok   bool
+	}{
+		{"", nil, true},
+		{"X-Api-Key:abc", [][2]string{{"X-Api-Key", "abc"}}, true},
+		{"X-Api-Key:abc;X-Tenant:foo", [][2]string{{"X-Api-Key", "abc"}, {"X-Tenant", "foo"}}, true},
+		{" X-Api-Key : abc ; ", [][2]string{{"X-Api-Key", "abc"}}, true},
+		{"X-Time:12:00", [][2]string{{"X-Time", "12:00"}}, true},
+		{"X-Empty:", [][2]string{{"X-Empty", ""}}, true},
+		{"X-Api-Key", nil, false},
+		{":abc", nil, false},
+		{"X Api:abc", nil, false},
+		{`X-"Api":abc`, nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseHeaders(tt.val)
+		if (err == nil) != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseHeaders(%q) = %q, %v, want %q, ok %v", tt.val, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestConfQuote(t *testing.T) {
+	tests := []struct {
+		val  string
+		want string
+	}{
+		{"", `""`},
+		{"abc", `"abc"`},
+		{"Bearer abc def", `"Bearer abc def"`},
+		{`a"b`, `"a\"b"`},
+		{`a\b`, `"a\\b"`},
+		{`\"`, `"\\\""`},
+	}
+	for _, tt := range tests {
+		if got := confQuote(tt.val); got != tt.want {
+			t.Errorf("confQuote(%q) = %s, want %s", tt.val, got, tt.want)
+		}
+	}
+}
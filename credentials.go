@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const secretsPath = "/run/secrets"
+
+// credentialHelperTimeout is how long the credential helper may run.
+const credentialHelperTimeout = 30 * time.Second
+
+// resolveSecret returns the value of an option which may reference a secret
+// stored elsewhere.
+func resolveSecret(val string) (string, error) {
+	switch {
+	case strings.HasPrefix(val, "vault://"):
+		return readVaultSecret(val)
+	case strings.HasPrefix(val, "secret://"):
+		name := strings.TrimPrefix(val, "secret://")
+		if name == "" || strings.Contains(name, "/") {
+			return "", fmt.Errorf("invalid secret reference %q", val)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(secretsPath, name))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	default:
+		return val, nil
+	}
+}
+
+// resolveCredentials sets the credentials the volume authenticates with,
+// from its options, the driver secrets file or by running its credential
+// helper.
+func (d *webdavfsDriver) resolveCredentials(v *webdavfsVolume) error {
+	var err error
+	if v.username, err = resolveSecret(v.Username); err != nil {
+		return err
+	}
+	// public shares take the token as the username
+	if v.ShareToken != "" {
+		v.username = v.ShareToken
+	}
+	if v.password, err = resolveSecret(v.Password); err != nil {
+		return err
+	}
+	if v.token, err = resolveSecret(v.BearerToken); err != nil {
+		return err
+	}
+	if v.clientSecret, err = resolveSecret(v.OAuth2ClientSecret); err != nil {
+		return err
+	}
+	if u, err := url.Parse(v.URL); err == nil && u.User == nil && !v.Anonymous && d.secretsFile != "" && v.username == "" && v.token == "" {
+		entries, err := readSecretsFile(d.secretsFile)
+		if err != nil {
+			return err
+		}
+		if username, password, ok := lookupSecrets(entries, u); ok {
+			v.username = username
+			if v.password, err = resolveSecret(password); err != nil {
+				return err
+			}
+		}
+	}
+	if v.CredentialHelper == "" {
+		return nil
+	}
+
+	// the helper prints username=, password= and/or token= lines
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, v.CredentialHelper, v.URL)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("credential helper timed out after %v", credentialHelperTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("credential helper: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "username":
+			v.username = kv[1]
+		case "password":
+			v.password = kv[1]
+		case "token":
+			v.token = kv[1]
+		}
+	}
+	return nil
+}
+
+// credentials returns the username and password the mount helper
+// authenticates with, taken from the url or the resolved volume credentials.
+func credentials(v *webdavfsVolume, u *url.URL) (string, string) {
+	var username, password string
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	} else {
+		username = v.username
+		password = v.password
+	}
+
+	// NTLM expects the user in DOMAIN\user form
+	if username != "" && v.Domain != "" {
+		username = v.Domain + "\\" + username
+	}
+	return username, password
+}
+
+// credentialsDigest fingerprints the resolved credentials of the volume.
+func credentialsDigest(v *webdavfsVolume) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(v.username+"\x00"+v.password+"\x00"+v.token+"\x00"+v.clientSecret)))
+}
+
+// mountInUse reports whether a container uses the mount on dir, through any
+// volume or definition replaced by recreate=true.
+func (d *webdavfsDriver) mountInUse(dir string) bool {
+	for _, v := range d.volumes {
+		for p := v; p != nil; p = p.previous {
+			if len(p.MountIDs) == 0 {
+				continue
+			}
+			if p.Mountpoint == dir {
+				return true
+			}
+			if base, ok := d.volumes[p.BaseVolume]; ok && base.Mountpoint == dir {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// watchCredentials periodically resolves the credentials of mounted volumes.
+// Rotated credentials are passed to the davProxy of a volume in place,
+// other volumes are remounted once no container uses them anymore, as the
+// containers keep the mount of the old helper.
+func (d *webdavfsDriver) watchCredentials(interval time.Duration) {
+	type resolved struct {
+		dir string
+		v   *webdavfsVolume
+		c   webdavfsVolume
+	}
+	for range time.Tick(interval) {
+		d.RLock()
+		var mounts []*resolved
+		for dir, m := range d.mounts {
+			if m.v.credentials != "" {
+				mounts = append(mounts, &resolved{dir: dir, v: m.v, c: *m.v})
+			}
+		}
+		d.RUnlock()
+
+		// credential helpers and vault may take a while
+		var rotated []*resolved
+		for _, r := range mounts {
+			if err := d.resolveCredentials(&r.c); err != nil {
+				logrus.WithField("mountpoint", r.dir).Error(err)
+				continue
+			}
+			if credentialsDigest(&r.c) != r.v.credentials {
+				rotated = append(rotated, r)
+			}
+		}
+
+		d.Lock()
+		for _, r := range rotated {
+			v := r.v
+			if m := d.mounts[r.dir]; m == nil || m.v != v {
+				continue
+			}
+
+			if v.proxy != nil {
+				logrus.WithField("mountpoint", r.dir).Info("credentials rotated")
+				v.username, v.password, v.token, v.clientSecret = r.c.username, r.c.password, r.c.token, r.c.clientSecret
+				v.credentials = credentialsDigest(v)
+				if u, err := url.Parse(v.url); err == nil {
+					v.proxy.setCredentials(v, u)
+				}
+				continue
+			}
+			if d.mountInUse(r.dir) {
+				if !v.rotationPending {
+					logrus.WithField("mountpoint", r.dir).Warn("credentials rotated, remounting once no container uses the volume")
+					v.rotationPending = true
+				}
+				continue
+			}
+
+			logrus.WithField("mountpoint", r.dir).Info("credentials rotated, remounting")
+			v.rotationPending = false
+			err := d.unmountVolume(v)
+			if err == nil {
+				err = d.mountVolume(v)
+			}
+			if err != nil {
+				name := d.volumeName(v)
+				logrus.WithField("volume", name).Error(err)
+				v.lastError, v.lastErrorAt = err.Error(), time.Now()
+				d.notifyMountFailure(name, v, err)
+			}
+		}
+		d.Unlock()
+	}
+}
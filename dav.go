@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// userAgent returns the User-Agent the driver identifies itself with.
+func userAgent(v *webdavfsVolume) string {
+	if v.UserAgent != "" {
+		return v.UserAgent
+	}
+	return "docker-volume-webdavfs/" + version
+}
+
+// davRequest sends a request to the WebDAV server of the volume, using its
+// credentials and TLS settings.
+func davRequest(v *webdavfsVolume, method, target string, body io.Reader) (*http.Response, error) {
+	req, err := newDavRequest(v, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	return davDo(v, req)
+}
+
+// newDavRequest returns a request to the WebDAV server of the volume with
+// its credentials, sent through its davProxy while it has one.
+func newDavRequest(v *webdavfsVolume, method, target string, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	username, password := credentials(v, u)
+	u.User = nil
+
+	token, cookies := v.token, ""
+	if v.proxy != nil {
+		// the proxy sends the credentials, token and cookies of the volume
+		u.Scheme, u.Host = "http", v.proxy.listener.Addr().String()
+		username, password, token = v.proxy.user, v.proxy.secret, ""
+	} else if needsHelperAuth(v) {
+		// don't fall back to Basic, which sends the password in clear text
+		scheme := v.Auth
+		if v.Krb5 {
+			scheme = "krb5"
+		}
+		return nil, fmt.Errorf("%s authentication is only supported by the mount helper", scheme)
+	} else if v.Auth == "sharepoint" {
+		if cookies, err = sharepointCookies(v); err != nil {
+			return nil, err
+		}
+		username = ""
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent(v))
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if cookies != "" {
+		req.Header.Set("Cookie", cookies)
+	}
+	headers, err := parseHeaders(v.Headers)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range headers {
+		req.Header.Set(h[0], h[1])
+	}
+	return req, nil
+}
+
+// davDo sends a request using the TLS settings of the volume.
+func davDo(v *webdavfsVolume, req *http.Request) (*http.Response, error) {
+	if v.proxy != nil {
+		// the proxy on the loopback applies them
+		return (&http.Client{Timeout: requestTimeout(v)}).Do(req)
+	}
+	client, err := newClient(v)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// checkDAV makes sure target is a WebDAV collection the volume can access.
+func checkDAV(v *webdavfsVolume, target string) error {
+	resp, err := davRequest(v, "OPTIONS", target, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+	if resp.Header.Get("Dav") == "" {
+		return fmt.Errorf("%s is not a WebDAV endpoint", target)
+	}
+	return nil
+}
+
+// createPath creates the collection target on the server, including any
+// missing parent collections.
+func createPath(v *webdavfsVolume, target string) error {
+	resp, err := davRequest(v, "MKCOL", target, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusMethodNotAllowed:
+		// created or already exists
+		return nil
+	case http.StatusConflict:
+		// parent collection missing
+		u, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+		parent := path.Dir(strings.TrimSuffix(u.Path, "/"))
+		if parent == u.Path || parent == "/" {
+			break
+		}
+		u.Path = parent + "/"
+		if err := createPath(v, u.String()); err != nil {
+			return err
+		}
+		return createPath(v, target)
+	}
+	return fmt.Errorf("MKCOL %s returned %s", target, resp.Status)
+}
+
+// davMembers returns the urls of the members of the collection target.
+func davMembers(v *webdavfsVolume, target string) ([]string, error) {
+	req, err := newDavRequest(v, "PROPFIND", target, strings.NewReader(`<?xml version="1.0"?><propfind xmlns="DAV:"><prop><resourcetype/></prop></propfind>`))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err := davDo(v, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s returned %s", redactURL(target), resp.Status)
+	}
+	var ms struct {
+		Responses []struct {
+			Href string `xml:"href"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %s: %v", redactURL(target), err)
+	}
+
+	base, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	var members []string
+	for _, r := range ms.Responses {
+		u, err := base.Parse(r.Href)
+		if err != nil {
+			return nil, err
+		}
+		// the collection itself is listed as well
+		if strings.TrimSuffix(u.Path, "/") == strings.TrimSuffix(base.Path, "/") {
+			continue
+		}
+		members = append(members, u.String())
+	}
+	return members, nil
+}
+
+// purgeVolume deletes the contents of the collection of the volume on the
+// server.
+func (d *webdavfsDriver) purgeVolume(v *webdavfsVolume) error {
+	if err := d.resolveCredentials(v); err != nil {
+		return err
+	}
+	if v.proxy == nil && needsDavProxy(v) {
+		u, err := url.Parse(v.URL)
+		if err != nil {
+			return err
+		}
+		v.url = v.URL
+		p, err := d.startDavProxy(v, u)
+		if err != nil {
+			return err
+		}
+		v.proxy = p
+		defer func() {
+			p.Close()
+			v.proxy = nil
+		}()
+	}
+	members, err := davMembers(v, v.URL)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		resp, err := davRequest(v, "DELETE", member, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("DELETE %s returned %s", redactURL(member), resp.Status)
+		}
+	}
+	return nil
+}
+
+// davQuota returns the RFC 4331 quota of the collection target, -1 when the
+// server doesn't report it.
+func davQuota(ctx context.Context, v *webdavfsVolume, target string) (available, used int64, err error) {
+	req, err := newDavRequest(v, "PROPFIND", target, strings.NewReader(`<?xml version="1.0"?><propfind xmlns="DAV:"><prop><quota-available-bytes/><quota-used-bytes/></prop></propfind>`))
+	if err != nil {
+		return -1, -1, err
+	}
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err := davDo(v, req.WithContext(ctx))
+	if err != nil {
+		return -1, -1, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return -1, -1, fmt.Errorf("PROPFIND %s returned %s", redactURL(target), resp.Status)
+	}
+	var ms struct {
+		Available []string `xml:"response>propstat>prop>quota-available-bytes"`
+		Used      []string `xml:"response>propstat>prop>quota-used-bytes"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return -1, -1, fmt.Errorf("PROPFIND %s: %v", redactURL(target), err)
+	}
+
+	available, used = -1, -1
+	for _, val := range ms.Available {
+		if n, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64); err == nil && n >= 0 {
+			available = n
+		}
+	}
+	for _, val := range ms.Used {
+		if n, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64); err == nil && n >= 0 {
+			used = n
+		}
+	}
+	return available, used, nil
+}
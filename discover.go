@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// discoverURL locates the WebDAV root of the server at rawurl, which may be
+// just a host name, using DNS SRV and TXT records (_webdavs._tcp and
+// _webdav._tcp) and the /.well-known/webdav redirect.
+func discoverURL(v *webdavfsVolume, rawurl string) (string, error) {
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "https://" + rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+
+	domain := u.Hostname()
+	for _, service := range []string{"webdavs", "webdav"} {
+		_, srvs, err := net.LookupSRV(service, "tcp", domain)
+		if err != nil || len(srvs) == 0 {
+			continue
+		}
+		u.Scheme = "https"
+		if service == "webdav" {
+			u.Scheme = "http"
+		}
+		u.Host = net.JoinHostPort(strings.TrimSuffix(srvs[0].Target, "."), fmt.Sprint(srvs[0].Port))
+
+		// the TXT record is next to the SRV record, not at its target
+		txts, _ := net.LookupTXT(fmt.Sprintf("_%s._tcp.%s", service, domain))
+		for _, txt := range txts {
+			if strings.HasPrefix(txt, "path=") {
+				u.Path = strings.TrimPrefix(txt, "path=")
+			}
+		}
+		break
+	}
+
+	client, err := newClient(v)
+	if err != nil {
+		return "", err
+	}
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	wellKnown := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/.well-known/webdav"}
+	resp, err := client.Get(wellKnown.String())
+	if err == nil {
+		resp.Body.Close()
+		if loc, err := resp.Location(); err == nil {
+			loc.User = u.User
+			return loc.String(), nil
+		}
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
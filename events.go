@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// watchDockerEvents reconciles the containers using the volumes whenever a
+// container dies, as Docker never unmounts the volumes of containers which
+// died unexpectedly in some cases (OOM, SIGKILL of the shim).
+func (d *webdavfsDriver) watchDockerEvents(socket string, delay time.Duration) {
+	client := unixClient(socket)
+	// events are streamed without a timeout
+	client.Timeout = 0
+	filters := url.QueryEscape(`{"type":["container"],"event":["die","destroy"]}`)
+
+	for {
+		d.reconcileContainers(socket)
+
+		resp, err := client.Get("http://docker/events?filters=" + filters)
+		if err == nil {
+			dec := json.NewDecoder(resp.Body)
+			var e struct{ Action string }
+			for dec.Decode(&e) == nil {
+				// give Docker time to unmount the volumes itself
+				time.Sleep(delay)
+				d.reconcileContainers(socket)
+			}
+			resp.Body.Close()
+			err = fmt.Errorf("events stream closed")
+		}
+		logrus.WithField("method", "events").Warn(err)
+		time.Sleep(time.Minute)
+	}
+}
+
+// reconcileContainers releases the volumes mounted for containers when no
+// existing container uses them anymore. Volumes still used by another
+// container are left alone, the mount request ids aren't container ids.
+func (d *webdavfsDriver) reconcileContainers(socket string) {
+	listed := time.Now()
+	resp, err := unixClient(socket).Get("http://docker/containers/json?all=1")
+	if err != nil {
+		logrus.WithField("method", "events").Warn(err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logrus.WithField("method", "events").Warnf("listing containers returned %s", resp.Status)
+		return
+	}
+	var containers []struct {
+		State  string
+		Mounts []struct {
+			Type string
+			Name string
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		logrus.WithField("method", "events").Warn(err)
+		return
+	}
+	used := map[string]bool{}
+	for _, c := range containers {
+		switch c.State {
+		case "created", "running", "paused", "restarting":
+			for _, m := range c.Mounts {
+				if m.Type == "volume" {
+					used[m.Name] = true
+				}
+			}
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	for name, v := range d.volumes {
+		// containers mounting the volume since may be missing in the list
+		if used[name] || v.lastMounted.After(listed) {
+			continue
+		}
+		var ids []string
+		for p := v; p != nil; p = p.previous {
+			for id := range p.MountIDs {
+				// mount requests without id aren't from containers
+				if id != "" {
+					ids = append(ids, id)
+				}
+			}
+		}
+		for _, id := range ids {
+			logrus.WithField("volume", name).Infof("releasing mount %s of a dead container", id)
+			if err := d.unmountContainer(name, id); err != nil {
+				logrus.WithField("volume", name).Error(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// volumeExport is the format volume definitions are exported in. The
+// volumes, which contain credentials, are encrypted with a passphrase.
+type volumeExport struct {
+	Encrypted []byte
+	Salt      []byte
+}
+
+const exportIterations = 100000
+
+// pbkdf2 derives a 32 byte key from the passphrase (RFC 8018 with
+// HMAC-SHA256, a single block).
+func pbkdf2(passphrase, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, passphrase)
+	mac.Write(salt)
+	binary.Write(mac, binary.BigEndian, uint32(1))
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+func exportCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, exportIterations))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// exportVolumes returns the volume definitions encrypted with passphrase.
+func (d *webdavfsDriver) exportVolumes(passphrase string) (*volumeExport, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase required")
+	}
+
+	data, err := json.Marshal(d.volumes)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := exportCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &volumeExport{Encrypted: aead.Seal(nonce, nonce, data, nil), Salt: salt}, nil
+}
+
+// importVolumes adds exported volume definitions, which are validated like
+// the options of created volumes. Volumes which already exist with the same
+// options are skipped.
+func (d *webdavfsDriver) importVolumes(e *volumeExport, passphrase string) (int, error) {
+	if passphrase == "" {
+		return 0, fmt.Errorf("passphrase required")
+	}
+	aead, err := exportCipher(passphrase, e.Salt)
+	if err != nil {
+		return 0, err
+	}
+	if len(e.Encrypted) < aead.NonceSize() {
+		return 0, fmt.Errorf("export malformed")
+	}
+	nonce, sealed := e.Encrypted[:aead.NonceSize()], e.Encrypted[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return 0, fmt.Errorf("wrong passphrase")
+	}
+	var exported map[string]*webdavfsVolume
+	if err := json.Unmarshal(data, &exported); err != nil {
+		return 0, err
+	}
+
+	// validated like created volumes, without the lock
+	volumes := map[string]*webdavfsVolume{}
+	for name, ev := range exported {
+		if ev.CreateOptions == nil {
+			return 0, fmt.Errorf("options of volume %s unknown, it was created by an older version", name)
+		}
+		v, err := d.newVolume(name, ev.CreateOptions)
+		if err != nil {
+			return 0, fmt.Errorf("volume %s: %v", name, err)
+		}
+		v.CreatedAt = ev.CreatedAt
+		volumes[name] = v
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	// check everything first, so nothing is imported on conflicts
+	all := map[string]*webdavfsVolume{}
+	for name, v := range volumes {
+		all[name] = v
+	}
+	for name, v := range d.volumes {
+		all[name] = v
+	}
+	for name, v := range volumes {
+		if old, ok := d.volumes[name]; ok && old.Mountpoint != v.Mountpoint {
+			return 0, fmt.Errorf("volume %s already exists with different options", name)
+		}
+		if err := checkBaseVolume(v, all); err != nil {
+			return 0, fmt.Errorf("volume %s: %v", name, err)
+		}
+	}
+	n := 0
+	for name, v := range volumes {
+		if _, ok := d.volumes[name]; ok {
+			continue
+		}
+		d.volumes[name] = v
+		n++
+	}
+	d.saveState()
+	return n, nil
+}
+
+// adminExport handles GET /export, the passphrase to encrypt the export
+// with is passed in the X-Passphrase header.
+func (d *webdavfsDriver) adminExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the volumes contain credentials, they are never exported in clear
+	passphrase := r.Header.Get("X-Passphrase")
+	if passphrase == "" {
+		http.Error(w, "X-Passphrase header required", http.StatusBadRequest)
+		return
+	}
+
+	d.RLock()
+	e, err := d.exportVolumes(passphrase)
+	if err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(e)
+	}
+	d.RUnlock()
+	d.audit.record("export", "", err, map[string]interface{}{"remote": r.RemoteAddr})
+	if err != nil {
+		logrus.WithField("method", "export").Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// adminImport handles POST /import with an export as body.
+func (d *webdavfsDriver) adminImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var e volumeExport
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<20)).Decode(&e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n, err := d.importVolumes(&e, r.Header.Get("X-Passphrase"))
+	d.audit.record("import", "", err, map[string]interface{}{"remote": r.RemoteAddr, "volumes": n})
+	if err != nil {
+		logrus.WithField("method", "import").Error(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logrus.WithField("method", "import").Infof("imported %d volumes", n)
+	fmt.Fprintf(w, "imported %d volumes\n", n)
+}
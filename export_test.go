@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 and the usual PBKDF2-HMAC-SHA256 test vectors
+	tests := []struct {
+		passphrase string
+		salt       string
+		iterations int
+		want       string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(pbkdf2([]byte(tt.passphrase), []byte(tt.salt), tt.iterations)); got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tt.passphrase, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func newTestDriver(t *testing.T) *webdavfsDriver {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "state"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	d, err := newwebdavfsDriver(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestExportImport(t *testing.T) {
+	src := newTestDriver(t)
+	for _, r := range []*volume.CreateRequest{
+		{Name: "share", Options: map[string]string{"url": "https://example.com/dav", "username": "user", "password": "secret"}},
+		{Name: "team", Options: map[string]string{"base_volume": "share", "subdir": "team"}},
+		{Name: "public", Options: map[string]string{"url": "https://example.com/public", "anonymous": "true", "ro": "true"}},
+	} {
+		if err := src.Create(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := src.exportVolumes("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newTestDriver(t)
+	if _, err := dst.importVolumes(e, "wrong"); err == nil {
+		t.Error("import with the wrong passphrase succeeded")
+	}
+	if _, err := dst.importVolumes(e, ""); err == nil {
+		t.Error("import without passphrase succeeded")
+	}
+	n, err := dst.importVolumes(e, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("imported %d volumes, want 3", n)
+	}
+	for name, v := range src.volumes {
+		got, ok := dst.volumes[name]
+		if !ok {
+			t.Errorf("volume %s not imported", name)
+			continue
+		}
+		// the mountpoint is derived from the options
+		if filepath.Base(got.Mountpoint) != filepath.Base(v.Mountpoint) || got.Password != v.Password {
+			t.Errorf("volume %s imported with other options", name)
+		}
+	}
+
+	// importing again skips the existing volumes
+	if n, err := dst.importVolumes(e, "passphrase"); err != nil || n != 0 {
+		t.Errorf("importing again = %d, %v, want 0", n, err)
+	}
+
+	// volumes of older versions can't be validated
+	src.volumes["old"] = &webdavfsVolume{URL: "https://example.com/old"}
+	if e, err = src.exportVolumes("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestDriver(t).importVolumes(e, "passphrase"); err == nil {
+		t.Error("import of a volume without its options succeeded")
+	}
+}
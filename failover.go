@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// failoverTransport sends the requests of a volume with mirrors to the
+// server it is connected to, moving on to the next mirror when connecting
+// fails, e.g. when the helper reconnects after the server went down.
+type failoverTransport struct {
+	sync.Mutex
+	next http.RoundTripper
+	// the url and the mirrors of the volume, starting with the one mounted
+	servers []*url.URL
+	active  int
+}
+
+// newFailoverTransport returns a failoverTransport for the volume mounted
+// from u.
+func newFailoverTransport(next http.RoundTripper, v *webdavfsVolume, u *url.URL) (*failoverTransport, error) {
+	t := &failoverTransport{next: next, servers: []*url.URL{u}}
+	for _, rawurl := range append([]string{v.URL}, v.Mirrors...) {
+		s, err := url.Parse(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		// requests keep the path they were sent with
+		if strings.TrimSuffix(s.Path, "/") != strings.TrimSuffix(u.Path, "/") {
+			return nil, fmt.Errorf("mirror %s doesn't have the path of %s", redactURL(rawurl), redactURL(u.String()))
+		}
+		if s.Host != u.Host || s.Scheme != u.Scheme {
+			t.servers = append(t.servers, s)
+		}
+	}
+	return t, nil
+}
+
+// current returns the url of the server requests are sent to.
+func (t *failoverTransport) current() *url.URL {
+	t.Lock()
+	defer t.Unlock()
+	return t.servers[t.active]
+}
+
+func (t *failoverTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.Lock()
+	active := t.active
+	t.Unlock()
+
+	var err error
+	for i := range t.servers {
+		s := t.servers[(active+i)%len(t.servers)]
+		var resp *http.Response
+		if resp, err = t.next.RoundTrip(withServer(r, s)); err == nil || !isDialError(err) {
+			if i > 0 {
+				logrus.WithField("method", "failover").Warnf("failed over to %s", redactURL(s.String()))
+				t.Lock()
+				t.active = (active + i) % len(t.servers)
+				t.Unlock()
+			}
+			return resp, err
+		}
+	}
+	return nil, err
+}
+
+// withServer returns a copy of the request sent to the server s instead,
+// mirrors serve the collection at the same path.
+func withServer(r *http.Request, s *url.URL) *http.Request {
+	if r.URL.Host == s.Host && r.URL.Scheme == s.Scheme {
+		return r
+	}
+	c := r.WithContext(r.Context())
+	u := *r.URL
+	u.Scheme, u.Host = s.Scheme, s.Host
+	c.URL, c.Host = &u, s.Host
+
+	c.Header = http.Header{}
+	for key, values := range r.Header {
+		c.Header[key] = values
+	}
+	if dest := r.Header.Get("Destination"); dest != "" {
+		if d, err := url.Parse(dest); err == nil {
+			d.Scheme, d.Host = s.Scheme, s.Host
+			c.Header.Set("Destination", d.String())
+		}
+	}
+	return c
+}
+
+// isDialError reports whether err happened connecting to the server, before
+// anything of the request was sent.
+func isDialError(err error) bool {
+	oe, ok := err.(*net.OpError)
+	return ok && oe.Op == "dial"
+}
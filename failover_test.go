@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestWithServer(t *testing.T) {
+	tests := []struct {
+		rawurl string
+		dest   string
+		server string
+		want   string
+		dst    string
+	}{
+		{"https://a.example.com/dav/file", "", "https://a.example.com/dav", "https://a.example.com/dav/file", ""},
+		{"https://a.example.com/dav/file", "", "https://b.example.com/dav", "https://b.example.com/dav/file", ""},
+		{"https://a.example.com/dav/file", "", "http://b.example.com:8080/dav", "http://b.example.com:8080/dav/file", ""},
+		{"https://a.example.com/dav/a", "https://a.example.com/dav/b", "https://b.example.com/dav", "https://b.example.com/dav/a", "https://b.example.com/dav/b"},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("MOVE", tt.rawurl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.dest != "" {
+			r.Header.Set("Destination", tt.dest)
+		}
+		s, err := url.Parse(tt.server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := withServer(r, s)
+		if got := c.URL.String(); got != tt.want {
+			t.Errorf("withServer(%s, %s) = %s, want %s", tt.rawurl, tt.server, got, tt.want)
+		}
+		if c.Host != c.URL.Host {
+			t.Errorf("withServer(%s, %s) sends Host %s", tt.rawurl, tt.server, c.Host)
+		}
+		if got := c.Header.Get("Destination"); got != tt.dst {
+			t.Errorf("withServer(%s, %s) Destination = %s, want %s", tt.rawurl, tt.server, got, tt.dst)
+		}
+		// the original request is retried on the next mirror
+		if r.URL.String() != tt.rawurl || r.Header.Get("Destination") != tt.dest {
+			t.Errorf("withServer(%s, %s) modified the request", tt.rawurl, tt.server)
+		}
+	}
+}
+
+func TestIsDialError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, false},
+		{errors.New("dial tcp: connection refused"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isDialError(tt.err); got != tt.want {
+			t.Errorf("isDialError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
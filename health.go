@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// backendHelpers are the executables the backends mount with.
+var backendHelpers = map[string]string{
+	"davfs2":        "mount.webdavfs",
+	"fuse-webdavfs": "webdavfs",
+	"rclone":        "rclone",
+	"sync":          "rclone",
+}
+
+type health struct {
+	Healthy       bool
+	Version       string
+	Commit        string
+	BuildDate     string
+	StateWritable bool
+	// whether the helpers of the backends are installed
+	Helpers map[string]bool
+	// volumes whose mount is gone or hangs
+	BrokenMounts []string
+}
+
+// mountAlive returns whether dir can be accessed within timeout, the mount
+// of a crashed FUSE helper fails with ENOTCONN and a hung one blocks.
+func mountAlive(dir string, timeout time.Duration) bool {
+	done := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(dir)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err == nil
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// checkHealth checks whether the state can be saved, the mount helpers are
+// installed and the mounts are alive.
+func (d *webdavfsDriver) checkHealth() *health {
+	h := &health{Version: version, Commit: commit, BuildDate: buildDate, Helpers: map[string]bool{}}
+
+	if f, err := ioutil.TempFile(filepath.Dir(d.statePath), ".health"); err == nil {
+		f.Close()
+		os.Remove(f.Name())
+		h.StateWritable = true
+	}
+
+	d.RLock()
+	used := map[string]bool{defaultBackend: true}
+	mounts := map[string]string{}
+	// mode=sync volumes are plain directories, not mounts
+	synced := map[string]bool{}
+	for name, v := range d.volumes {
+		if v.BaseVolume == "" {
+			used[v.backend()] = true
+		}
+		if d.mounts[v.Mountpoint] != nil {
+			mounts[name] = v.Mountpoint
+			synced[name] = v.BaseVolume == "" && v.backend() == "sync"
+		}
+	}
+	d.RUnlock()
+
+	h.Healthy = h.StateWritable
+	for backend, helper := range backendHelpers {
+		_, err := exec.LookPath(helper)
+		h.Helpers[backend] = err == nil
+		if err != nil && used[backend] && !d.dryrun {
+			h.Healthy = false
+		}
+	}
+	if !d.dryrun {
+		for name, dir := range mounts {
+			if (!synced[name] && !isMounted(dir)) || !mountAlive(dir, 5*time.Second) {
+				h.BrokenMounts = append(h.BrokenMounts, name)
+			}
+		}
+	}
+	if len(h.BrokenMounts) > 0 {
+		h.Healthy = false
+	}
+	return h
+}
+
+// serveHealth handles GET /health, it responds with 503 when unhealthy.
+func (d *webdavfsDriver) serveHealth(w http.ResponseWriter, r *http.Request) {
+	h := d.checkHealth()
+	w.Header().Set("Content-Type", "application/json")
+	if !h.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
+
+// listenHealth serves only the health endpoint on addr.
+func (d *webdavfsDriver) listenHealth(addr string) {
+	l, err := listen(addr)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", d.serveHealth)
+
+	logrus.Infof("health listening on %s", addr)
+	logrus.Error(http.Serve(l, mux))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixClient returns an http client connecting to the unix socket path.
+func unixClient(path string) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// healthcheck probes the plugin socket, and the health endpoint when
+// HEALTH_ADDRESS is set, for the healthcheck subcommand.
+func healthcheck(socket string) error {
+	resp, err := unixClient(socket).Post("http://plugin/VolumeDriver.Capabilities", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("plugin socket: %s", resp.Status)
+	}
+
+	addr := os.Getenv("HEALTH_ADDRESS")
+	if addr == "" {
+		return nil
+	}
+	client, rawurl := &http.Client{Timeout: time.Minute}, "http://"+addr+"/health"
+	if strings.HasPrefix(addr, "unix:") {
+		client, rawurl = unixClient(strings.TrimPrefix(addr, "unix:")), "http://plugin/health"
+	}
+	if resp, err = client.Get(rawurl); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health: %s", resp.Status)
+	}
+	return nil
+}
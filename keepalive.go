@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// keepalive sends an OPTIONS request to the server of the volume every
+// interval until done is closed, keeping sessions and NAT or firewall state
+// of idle volumes alive. It goes through the davProxy p when the volume has
+// one, reusing the connections of the mount helper.
+func keepalive(v *webdavfsVolume, p *davProxy, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	target := v.url
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			var err error
+			if p != nil {
+				err = p.probe()
+			} else {
+				err = probeServer(v, target)
+			}
+			if err != nil {
+				logrus.WithField("method", "keepalive").Warn(err)
+			}
+		}
+	}
+}
+
+// probeServer sends an OPTIONS request to target, without credentials when
+// only the mount helper can authenticate.
+func probeServer(v *webdavfsVolume, target string) error {
+	req, err := http.NewRequest("OPTIONS", target, nil)
+	if !needsHelperAuth(v) {
+		req, err = newDavRequest(v, "OPTIONS", target, nil)
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := davDo(v, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	logrus.WithField("method", "keepalive").Debugf("%s: %s", redactURL(target), resp.Status)
+	return nil
+}
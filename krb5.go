@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// krb5CCache returns the Kerberos credential cache used by the volume.
+func (d *webdavfsDriver) krb5CCache(v *webdavfsVolume) string {
+	return "FILE:" + filepath.Join(d.confPath, filepath.Base(v.Mountpoint)+".ccache")
+}
+
+// kinit obtains a Kerberos ticket for the volume principal from its keytab.
+func (d *webdavfsDriver) kinit(v *webdavfsVolume) error {
+	if err := os.MkdirAll(d.confPath, 0700); err != nil {
+		return err
+	}
+
+	args := []string{"-k", "-c", d.krb5CCache(v)}
+	if v.Krb5Keytab != "" {
+		args = append(args, "-t", v.Krb5Keytab)
+	}
+	if v.Krb5Principal != "" {
+		args = append(args, v.Krb5Principal)
+	}
+	logrus.WithField("method", "kinit").Debug(args)
+
+	out, err := exec.Command("kinit", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("kinit: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// renewKrb5Ticket obtains a fresh ticket every hour until done is closed, so
+// the ticket doesn't expire while the volume is mounted.
+func (d *webdavfsDriver) renewKrb5Ticket(v *webdavfsVolume, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := d.kinit(v); err != nil {
+				logrus.WithField("method", "renewKrb5Ticket").Error(err)
+			}
+		}
+	}
+}
@@ -1,18 +1,21 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/go-plugins-helpers/volume"
@@ -20,41 +23,226 @@ import (
 
 const socketAddress = "/run/docker/plugins/webdavfs.sock"
 
+// version, commit and buildDate are set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
 type webdavfsVolume struct {
-	URL      string
-	Username string
-	Password string
-	Conf     string
+	URL         string
+	Mirrors     []string
+	Path        string
+	BaseVolume  string
+	Subdir      string
+	Automount   bool
+	Purge       bool
+	Protected   bool
+	IdleTimeout int
+	Username    string
+	Password    string
+	Auth        string
+	Domain      string
+	Conf        string
+	ConfContent string
+	Backend     string
+	Mode        string
+	Anonymous   bool
+	Discover    bool
+	Nextcloud   bool
+	CreatePath  bool
+	ShareToken  string
+	BearerToken string
+	FedAuth     string
+	RtFa        string
+
+	CredentialHelper string
+
+	OAuth2TokenURL     string
+	OAuth2ClientID     string
+	OAuth2ClientSecret string
+
+	ClientCert string
+	ClientKey  string
+	CACert     string
+	Insecure   bool
+
+	TLSMinVersion string
+	TLSCiphers    string
+
+	Headers       string
+	UserAgent     string
+	Proxy         string
+	BindAddress   string
+	BindInterface string
+	IPVersion     string
+
+	ConnectTimeout int
+	ReadTimeout    int
+
+	MountRetries    int
+	MountRetryDelay int
+
+	Overlay bool
+
+	Encrypt         bool
+	EncryptPassword string
+	Compress        bool
+
+	SyncInterval  int
+	SyncDirection string
+
+	VFSCacheMode    string
+	VFSCacheMaxSize string
+	Transfers       int
+	ChunkSize       int64
+
+	UseLocks  *bool
+	CacheSize int
+	CacheDir  string
+	BufSize   int
+
+	DirRefresh  int
+	FileRefresh int
+	DelayUpload *int
+	NoCache     bool
+
+	// server quirk workarounds
+	IfMatchBug      *bool
+	IgnoreDAVHeader *bool
+	DropWeakEtags   *bool
+	UseCompression  *bool
+
+	ServerCharset string
+
+	KeepaliveInterval int
+	BWLimit           string
+	HTTPDebug         bool
+	Stats             bool
+
+	Krb5          bool
+	Krb5Keytab    string
+	Krb5Principal string
+
 	UID      string
 	GID      string
 	FileMode string
 	DirMode  string
+	Umask    string
 	Ro       bool
 	Rw       bool
 	Exec     bool
 	Suid     bool
 	Grpid    bool
 	Netdev   bool
+	Atime    string
+	Options  string
+
+	AllowOther bool
+	AllowRoot  bool
+
+	// options the volume was created with
+	CreateOptions map[string]string
+	Labels        map[string]string
+	CreatedAt     string `json:",omitempty"`
+
+	Mountpoint string
+	// ids of the mount requests of containers using the volume
+	MountIDs map[string]bool
+
+	mounted bool
+	// error of the last failed mount or unmount, cleared by a successful
+	// mount
+	lastError   string
+	lastErrorAt time.Time
+	// disk usage of the local cache, computed at most once a minute
+	cacheBytes   int64
+	cacheChecked time.Time
+	// requests and bytes transferred through the davProxy
+	stats *transferStats
+	// since when the mounted volume isn't used by any container
+	idleSince time.Time
+	// when a container last mounted the volume
+	lastMounted time.Time
+	proxy       *davProxy
+	done        chan struct{}
+
+	// set through the admin API to remove a protected volume
+	allowRemove bool
 
-	Mountpoint  string
-	connections int
+	// definition replaced by recreate=true, still used by containers
+	previous *webdavfsVolume
+
+	// url the volume is mounted from
+	url string
+	// credentials were rotated while containers use the volume
+	rotationPending bool
+
+	// credentials resolved at mount time
+	username     string
+	password     string
+	token        string
+	clientSecret string
+	credentials  string
 }
 
 type webdavfsDriver struct {
 	sync.RWMutex
 
-	root      string
-	statePath string
-	volumes   map[string]*webdavfsVolume
+	root        string
+	confPath    string
+	logPath     string
+	cachePath   string
+	overlayPath string
+	statePath   string
+	trashPath   string
+	volumes     map[string]*webdavfsVolume
+	trash       map[string]*trashedVolume
+	retention   time.Duration
+	mounts      map[string]*sharedMount
+	// closed once the mountpoint is mounted or unmounted, which release the
+	// driver lock while waiting to retry or synchronising
+	mounting map[string]chan struct{}
+	defaults map[string]string
+
+	secretsFile string
+	mounters    map[string]mounter
+	breaker     *mountBreaker
+	metrics     *metrics
+	audit       *auditLog
+	// url failed mounts are posted to
+	webhook string
+
+	dryrun         bool
+	mountNamespace bool
 }
 
 func newwebdavfsDriver(root string) (*webdavfsDriver, error) {
 	logrus.WithField("method", "new driver").Debug(root)
 
 	d := &webdavfsDriver{
-		root:      filepath.Join(root, "volumes"),
-		statePath: filepath.Join(root, "state", "webdavfs-state.json"),
-		volumes:   map[string]*webdavfsVolume{},
+		root:        filepath.Join(root, "volumes"),
+		confPath:    filepath.Join(root, "conf"),
+		logPath:     filepath.Join(root, "log"),
+		cachePath:   filepath.Join(root, "cache"),
+		overlayPath: filepath.Join(root, "overlay"),
+		statePath:   filepath.Join(root, "state", "webdavfs-state.json"),
+		trashPath:   filepath.Join(root, "state", "webdavfs-trash.json"),
+		volumes:     map[string]*webdavfsVolume{},
+		trash:       map[string]*trashedVolume{},
+		mounts:      map[string]*sharedMount{},
+		mounting:    map[string]chan struct{}{},
+		defaults:    map[string]string{},
+		breaker:     newMountBreaker(5, time.Minute),
+		metrics:     newMetrics(),
+	}
+	d.mounters = map[string]mounter{
+		"davfs2":        &davfs2Mounter{d: d},
+		"fuse-webdavfs": &webdavfsMounter{d: d},
+		"rclone":        &rcloneMounter{d: d},
+		"sync":          newSyncMounter(d),
 	}
 
 	data, err := ioutil.ReadFile(d.statePath)
@@ -69,6 +257,9 @@ func newwebdavfsDriver(root string) (*webdavfsDriver, error) {
 			return nil, err
 		}
 	}
+	if err := d.loadTrash(); err != nil {
+		return nil, err
+	}
 
 	return d, nil
 }
@@ -85,23 +276,412 @@ func (d *webdavfsDriver) saveState() {
 	}
 }
 
-func (d *webdavfsDriver) Create(r *volume.CreateRequest) error {
-	logrus.WithField("method", "create").Debugf("%#v", r)
+func (d *webdavfsDriver) Create(r *volume.CreateRequest) (err error) {
+	logrus.WithField("method", "create").Debugf("%s %v", r.Name, redactOptions(r.Options))
+	defer logRequest("create", r.Name, time.Now(), &err)
+	defer func() {
+		d.audit.record("create", r.Name, err, map[string]interface{}{"options": redactOptions(r.Options)})
+	}()
+
+	if val, ok := r.Options["restore"]; ok {
+		if b, err := parseBool("restore", val); err != nil || !b {
+			return fmt.Errorf("'restore' option malformed")
+		}
+		if len(r.Options) > 1 {
+			return fmt.Errorf("'restore' option can't be combined with other options")
+		}
+		d.Lock()
+		defer d.Unlock()
+		return d.restoreVolume(r.Name)
+	}
+
+	recreate := false
+	if val, ok := r.Options["recreate"]; ok {
+		b, err := parseBool("recreate", val)
+		if err != nil {
+			return err
+		}
+		recreate = b
+	}
+
+	// options of the volume itself, starting from those of another volume
+	// with from=<volume>
+	created := map[string]string{}
+	if from, ok := r.Options["from"]; ok {
+		d.RLock()
+		var options map[string]string
+		src, ok := d.volumes[from]
+		if ok {
+			options = src.CreateOptions
+		}
+		for key, val := range options {
+			created[key] = val
+		}
+		d.RUnlock()
+		if !ok {
+			return fmt.Errorf("volume %s not found", from)
+		}
+		if options == nil {
+			return fmt.Errorf("options of volume %s unknown, it was created by an older version", from)
+		}
+	}
+	for key, val := range r.Options {
+		if key != "from" && key != "recreate" {
+			created[key] = val
+		}
+	}
+	// without the lock, credential helpers, Vault and the server may take a
+	// while
+	v, err := d.newVolume(r.Name, created)
+	if err != nil {
+		return err
+	}
 
 	d.Lock()
 	defer d.Unlock()
-	v := &webdavfsVolume{}
+	if err := checkBaseVolume(v, d.volumes); err != nil {
+		return err
+	}
+	return d.addVolume(r.Name, v, recreate)
+}
 
-	for key, val := range r.Options {
+// newVolume returns the volume with the options it is created with, which
+// are validated and combined with the driver defaults.
+func (d *webdavfsDriver) newVolume(name string, created map[string]string) (*webdavfsVolume, error) {
+	v := &webdavfsVolume{CreateOptions: created}
+
+	options := map[string]string{}
+	for key, val := range d.defaults {
+		options[key] = val
+	}
+	for key, val := range created {
+		options[key] = val
+	}
+
+	var urlMirrors []string
+	for key, val := range options {
 		switch key {
 		case "url":
-			v.URL = val
+			// url=<url>,<mirror>,... lists the mirrors as well
+			urls := strings.Split(val, ",")
+			v.URL = strings.TrimSpace(urls[0])
+			for _, mirror := range urls[1:] {
+				urlMirrors = append(urlMirrors, strings.TrimSpace(mirror))
+			}
+		case "mirrors":
+			v.Mirrors = strings.Fields(val)
+		case "path":
+			v.Path = val
+		case "protected":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Protected = b
+		case "purge":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Purge = b
+		case "labels":
+			labels, err := parseLabels(val)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' option malformed: %v", key, err)
+			}
+			v.Labels = labels
+		case "automount":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Automount = b
+		case "idle_timeout":
+			n, err := parseSeconds(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.IdleTimeout = n
+		case "base_volume":
+			v.BaseVolume = val
+		case "subdir":
+			v.Subdir = val
 		case "username":
 			v.Username = val
 		case "password":
 			v.Password = val
 		case "conf":
 			v.Conf = val
+		case "conf_content":
+			content, err := parseConfContent(val)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' option malformed: %v", key, err)
+			}
+			v.ConfContent = content
+		case "backend":
+			v.Backend = val
+		case "mode":
+			if val != "mount" && val != "sync" {
+				return nil, fmt.Errorf("unknown '%s' option %q", key, val)
+			}
+			v.Mode = val
+		case "encrypt":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Encrypt = b
+		case "encrypt_password":
+			v.EncryptPassword = val
+		case "compress":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Compress = b
+		case "overlay":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Overlay = b
+		case "sync_interval":
+			n, err := parseSeconds(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.SyncInterval = n
+		case "sync_direction":
+			if val != "download" && val != "upload" && val != "both" {
+				return nil, fmt.Errorf("unknown '%s' option %q", key, val)
+			}
+			v.SyncDirection = val
+		case "auth":
+			v.Auth = val
+		case "domain":
+			v.Domain = val
+		case "anonymous":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Anonymous = b
+		case "discover":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Discover = b
+		case "nextcloud":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Nextcloud = b
+		case "create_path":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.CreatePath = b
+		case "credential_helper":
+			v.CredentialHelper = val
+		case "share_token":
+			v.ShareToken = val
+		case "fedauth":
+			v.FedAuth = val
+		case "rtfa":
+			v.RtFa = val
+		case "bearer_token":
+			v.BearerToken = val
+		case "oauth2_token_url":
+			v.OAuth2TokenURL = val
+		case "oauth2_client_id":
+			v.OAuth2ClientID = val
+		case "oauth2_client_secret":
+			v.OAuth2ClientSecret = val
+		case "client_cert":
+			v.ClientCert = val
+		case "client_key":
+			v.ClientKey = val
+		case "ca_cert":
+			v.CACert = val
+		case "insecure":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Insecure = b
+		case "tls_min_version":
+			v.TLSMinVersion = val
+		case "tls_ciphers":
+			v.TLSCiphers = val
+		case "headers":
+			v.Headers = val
+		case "user_agent":
+			v.UserAgent = val
+		case "proxy":
+			v.Proxy = val
+		case "bind_address":
+			v.BindAddress = val
+		case "bind_interface":
+			v.BindInterface = val
+		case "ip_version":
+			v.IPVersion = val
+		case "connect_timeout":
+			n, err := parseSeconds(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.ConnectTimeout = n
+		case "bwlimit":
+			if _, err := parseRate(val); err != nil {
+				return nil, fmt.Errorf("'%s' option malformed: %v", key, err)
+			}
+			v.BWLimit = val
+		case "http_debug":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.HTTPDebug = b
+		case "stats":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Stats = b
+		case "vfs_cache_mode":
+			switch val {
+			case "off", "minimal", "writes", "full":
+			default:
+				return nil, fmt.Errorf("unknown '%s' option %q", key, val)
+			}
+			v.VFSCacheMode = val
+		case "vfs_cache_max_size":
+			v.VFSCacheMaxSize = val
+		case "transfers":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("'%s' option malformed", key)
+			}
+			v.Transfers = n
+		case "use_locks":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.UseLocks = &b
+		case "if_match_bug":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.IfMatchBug = &b
+		case "ignore_dav_header":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.IgnoreDAVHeader = &b
+		case "drop_weak_etags":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.DropWeakEtags = &b
+		case "use_compression":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.UseCompression = &b
+		case "server_charset":
+			if val == "" || strings.ContainsAny(val, " \t\"") {
+				return nil, fmt.Errorf("'%s' option malformed", key)
+			}
+			v.ServerCharset = val
+		case "cache_size":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("'%s' option malformed, expected MiB", key)
+			}
+			v.CacheSize = n
+		case "cache_dir":
+			if !filepath.IsAbs(val) {
+				return nil, fmt.Errorf("'%s' option must be an absolute path", key)
+			}
+			v.CacheDir = val
+		case "buf_size":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("'%s' option malformed, expected KiB", key)
+			}
+			v.BufSize = n
+		case "dir_refresh":
+			n, err := parseSeconds(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.DirRefresh = n
+		case "file_refresh":
+			n, err := parseSeconds(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.FileRefresh = n
+		case "no_cache":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.NoCache = b
+		case "delay_upload":
+			n, err := parseSeconds(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.DelayUpload = &n
+		case "chunk_size":
+			n, err := parseRate(val)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' option malformed: %v", key, err)
+			}
+			v.ChunkSize = n
+		case "keepalive_interval":
+			n, err := parseSeconds(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.KeepaliveInterval = n
+		case "mount_retries":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("'%s' option malformed", key)
+			}
+			v.MountRetries = n
+		case "mount_retry_delay":
+			n, err := parseSeconds(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.MountRetryDelay = n
+		case "read_timeout":
+			n, err := parseSeconds(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.ReadTimeout = n
+		case "krb5":
+			b, err := parseBool(key, val)
+			if err != nil {
+				return nil, err
+			}
+			v.Krb5 = b
+		case "krb5_keytab":
+			v.Krb5Keytab = val
+		case "krb5_principal":
+			v.Krb5Principal = val
 		case "uid":
 			v.UID = val
 		case "gid":
@@ -110,6 +690,11 @@ func (d *webdavfsDriver) Create(r *volume.CreateRequest) error {
 			v.FileMode = val
 		case "dir_mode":
 			v.DirMode = val
+		case "umask":
+			if n, err := strconv.ParseUint(val, 8, 32); err != nil || n > 0777 {
+				return nil, fmt.Errorf("'%s' option malformed, expected octal", key)
+			}
+			v.Umask = val
 		case "ro":
 			v.Ro = true
 		case "rw":
@@ -122,130 +707,613 @@ func (d *webdavfsDriver) Create(r *volume.CreateRequest) error {
 			v.Grpid = true
 		case "_netdav":
 			v.Netdev = true
+		case "noatime", "relatime":
+			v.Atime = key
+		case "allow_other":
+			v.AllowOther = true
+		case "allow_root":
+			v.AllowRoot = true
+		case "o":
+			v.Options = val
 		default:
-			return logError("unknown option %q", val)
+			return nil, fmt.Errorf("unknown option %q", key)
 		}
 	}
+	if len(urlMirrors) > 0 {
+		v.Mirrors = append(urlMirrors, v.Mirrors...)
+	}
 
+	if v.BaseVolume != "" {
+		return d.subdirVolume(v)
+	}
+	if v.Subdir != "" {
+		return nil, fmt.Errorf("'subdir' option requires 'base_volume'")
+	}
 	if v.URL == "" {
-		return logError("'url' option required")
+		return nil, fmt.Errorf("'url' option required")
+	}
+	if _, ok := d.mounters[v.backend()]; !ok {
+		return nil, fmt.Errorf("backend %q not available", v.backend())
+	}
+	if err := checkBackendOptions(v); err != nil {
+		return nil, err
 	}
-	_, err := url.Parse(v.URL)
+	if v.Encrypt {
+		if v.EncryptPassword == "" {
+			return nil, fmt.Errorf("'encrypt' option requires 'encrypt_password'")
+		}
+		if v.backend() != "rclone" && v.backend() != "sync" {
+			return nil, fmt.Errorf("'encrypt' option requires 'backend=rclone' or 'mode=sync'")
+		}
+	}
+	if v.Compress && v.backend() != "rclone" && v.backend() != "sync" {
+		return nil, fmt.Errorf("'compress' option requires 'backend=rclone' or 'mode=sync'")
+	}
+	if v.Umask != "" {
+		umask, _ := strconv.ParseUint(v.Umask, 8, 32)
+		if v.FileMode == "" {
+			v.FileMode = fmt.Sprintf("%04o", 0666&^umask)
+		}
+		if v.DirMode == "" {
+			v.DirMode = fmt.Sprintf("%04o", 0777&^umask)
+		}
+	}
+	if v.NoCache {
+		if v.Mode == "sync" {
+			return nil, fmt.Errorf("'no_cache' option can't be combined with 'mode=sync'")
+		}
+		if v.DirRefresh > 0 || v.FileRefresh > 0 || v.DelayUpload != nil || v.VFSCacheMode != "" {
+			return nil, fmt.Errorf("'no_cache' option can't be combined with other cache options")
+		}
+	}
+	if v.AllowOther && v.AllowRoot {
+		return nil, fmt.Errorf("'allow_other' and 'allow_root' options are mutually exclusive")
+	}
+	if v.Options != "" && v.Mode == "sync" {
+		return nil, fmt.Errorf("'o' option can't be combined with 'mode=sync'")
+	}
+	if v.ChunkSize > 0 {
+		if !v.Nextcloud {
+			return nil, fmt.Errorf("'chunk_size' option requires 'nextcloud=true'")
+		}
+		if v.backend() != "rclone" && v.backend() != "sync" {
+			return nil, fmt.Errorf("'chunk_size' option requires 'backend=rclone' or 'mode=sync'")
+		}
+	}
+	if v.Overlay {
+		if v.Mode == "sync" {
+			return nil, fmt.Errorf("'overlay' option can't be combined with 'mode=sync'")
+		}
+		// the share itself is only read, writes go to the overlay
+		v.Ro, v.Rw = true, false
+	}
+	if v.Discover {
+		discovered, err := discoverURL(v, v.URL)
+		if err != nil {
+			return nil, err
+		}
+		v.URL = discovered
+	}
+	u, err := url.Parse(v.URL)
 	if err != nil {
-		return logError("'url' option malformed")
+		return nil, fmt.Errorf("'url' option malformed")
+	}
+	for _, mirror := range v.Mirrors {
+		m, err := url.Parse(mirror)
+		if err != nil || m.Host == "" {
+			return nil, fmt.Errorf("'mirrors' option malformed")
+		}
+		// the requests are sent to the mirrors unchanged
+		if strings.TrimSuffix(m.Path, "/") != strings.TrimSuffix(u.Path, "/") {
+			return nil, fmt.Errorf("'mirrors' option malformed, mirrors must have the path of 'url'")
+		}
+	}
+	if len(v.Mirrors) > 0 && (v.Nextcloud || v.ShareToken != "") {
+		return nil, fmt.Errorf("'nextcloud' and 'share_token' options can't be combined with 'mirrors'")
+	}
+	if err := d.resolveCredentials(v); err != nil {
+		return nil, err
+	}
+	if v.Anonymous && (v.username != "" || v.token != "" || v.CredentialHelper != "" || v.OAuth2TokenURL != "" || v.Krb5 || v.Auth != "") {
+		return nil, fmt.Errorf("'anonymous' option can't be combined with credentials")
+	}
+	if v.BearerToken != "" && v.Username != "" {
+		return nil, fmt.Errorf("'bearer_token' and 'username' options are mutually exclusive")
+	}
+	if v.OAuth2TokenURL != "" || v.OAuth2ClientID != "" || v.OAuth2ClientSecret != "" {
+		if v.OAuth2TokenURL == "" || v.OAuth2ClientID == "" || v.OAuth2ClientSecret == "" {
+			return nil, fmt.Errorf("'oauth2_token_url', 'oauth2_client_id' and 'oauth2_client_secret' options must be set together")
+		}
+		if v.BearerToken != "" {
+			return nil, fmt.Errorf("'bearer_token' and 'oauth2_token_url' options are mutually exclusive")
+		}
+	}
+	switch v.Auth {
+	case "", "basic":
+		if v.Domain != "" {
+			return nil, fmt.Errorf("'domain' option requires 'auth=ntlm'")
+		}
+	case "digest", "ntlm":
+		if v.Domain != "" && v.Auth != "ntlm" {
+			return nil, fmt.Errorf("'domain' option requires 'auth=ntlm'")
+		}
+		if v.Username == "" && u.User == nil {
+			return nil, fmt.Errorf("'auth=%s' requires a username", v.Auth)
+		}
+	case "sharepoint":
+		if (v.FedAuth == "") != (v.RtFa == "") {
+			return nil, fmt.Errorf("'fedauth' and 'rtfa' options must be set together")
+		}
+		if v.FedAuth == "" && v.Username == "" && u.User == nil {
+			return nil, fmt.Errorf("'auth=sharepoint' requires a username or the 'fedauth' and 'rtfa' options")
+		}
+	default:
+		return nil, fmt.Errorf("unknown 'auth' option %q", v.Auth)
+	}
+	if v.Auth != "sharepoint" && (v.FedAuth != "" || v.RtFa != "") {
+		return nil, fmt.Errorf("'fedauth' and 'rtfa' options require 'auth=sharepoint'")
+	}
+	if needsDavProxy(v) && needsHelperAuth(v) {
+		return nil, fmt.Errorf("'krb5', 'auth=digest' and 'auth=ntlm' can't be combined with options passing requests through the plugin (oauth2, user_agent, socks5 proxy, bind_address, bind_interface, ip_version, bwlimit, stats, http_debug)")
+	}
+	if v.ClientCert != "" || v.ClientKey != "" {
+		if _, err := loadClientCert(v); err != nil {
+			return nil, fmt.Errorf("'client_cert' and 'client_key' options invalid: %v", err)
+		}
+	}
+	if v.CACert != "" {
+		if _, err := loadCACert(v); err != nil {
+			return nil, fmt.Errorf("'ca_cert' option invalid: %v", err)
+		}
+	}
+	if _, err := parseHeaders(v.Headers); err != nil {
+		return nil, fmt.Errorf("'headers' option malformed: %v", err)
+	}
+	switch v.IPVersion {
+	case "", "auto", "4", "6":
+	default:
+		return nil, fmt.Errorf("unknown 'ip_version' option %q", v.IPVersion)
+	}
+	if v.BindAddress != "" && v.BindInterface != "" {
+		return nil, fmt.Errorf("'bind_address' and 'bind_interface' options are mutually exclusive")
+	}
+	if _, err := newTransport(v); err != nil {
+		return nil, err
+	}
+	if v.Nextcloud {
+		if v.URL, err = nextcloudURL(v, u); err != nil {
+			return nil, err
+		}
+		if err := checkDAV(v, v.URL); err != nil {
+			return nil, err
+		}
+	}
+	if v.ShareToken != "" {
+		if v.Nextcloud || v.Username != "" || u.User != nil {
+			return nil, fmt.Errorf("'share_token' option can't be combined with 'nextcloud' or a username")
+		}
+		v.URL = publicShareURL(u)
+		v.username = v.ShareToken
+		if err := checkDAV(v, v.URL); err != nil {
+			return nil, err
+		}
+	}
+	if v.Path != "" {
+		if v.URL, err = joinURLPath(v.URL, v.Path); err != nil {
+			return nil, fmt.Errorf("'path' option malformed: %v", err)
+		}
+		for i, mirror := range v.Mirrors {
+			if v.Mirrors[i], err = joinURLPath(mirror, v.Path); err != nil {
+				return nil, fmt.Errorf("'path' option malformed: %v", err)
+			}
+		}
+	}
+	if v.Insecure {
+		logrus.WithField("volume", name).Warn("'insecure' option set, TLS certificate verification is disabled")
+	}
+	if !v.Krb5 && (v.Krb5Keytab != "" || v.Krb5Principal != "") {
+		return nil, fmt.Errorf("'krb5_keytab' and 'krb5_principal' options require 'krb5=true'")
+	}
+	if v.Mountpoint, err = d.mountpoint(v); err != nil {
+		return nil, err
 	}
-	v.Mountpoint = filepath.Join(d.root, fmt.Sprintf("%x", md5.Sum([]byte(v.URL))))
 
-	d.volumes[r.Name] = v
-	d.saveState()
+	return v, nil
+}
+
+// subdirVolume validates a volume bind mounting a subdirectory of the mount of
+// its base volume, so many volumes can share a single mount.
+func (d *webdavfsDriver) subdirVolume(v *webdavfsVolume) (*webdavfsVolume, error) {
+	for key := range v.CreateOptions {
+		switch key {
+		case "base_volume", "subdir", "automount", "idle_timeout", "protected", "labels":
+		default:
+			return nil, fmt.Errorf("'base_volume' option can't be combined with '%s'", key)
+		}
+	}
+	subdir := path.Clean("/" + v.Subdir)
+	if subdir != "/"+strings.Trim(v.Subdir, "/") {
+		return nil, fmt.Errorf("'subdir' option malformed")
+	}
+	v.Subdir = subdir
+
+	var err error
+	if v.Mountpoint, err = d.mountpoint(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
 
+// checkBaseVolume makes sure the base volume of a subdirectory volume is one
+// of volumes and mounts a share itself.
+func checkBaseVolume(v *webdavfsVolume, volumes map[string]*webdavfsVolume) error {
+	if v.BaseVolume == "" {
+		return nil
+	}
+	base, ok := volumes[v.BaseVolume]
+	if !ok {
+		return fmt.Errorf("base volume %s not found", v.BaseVolume)
+	}
+	if base.BaseVolume != "" {
+		return fmt.Errorf("base volume %s has a base volume itself", v.BaseVolume)
+	}
 	return nil
 }
 
-func (d *webdavfsDriver) Remove(r *volume.RemoveRequest) error {
+// addVolume adds a created volume. Creating an existing volume again only
+// succeeds when the options are the same, which the mountpoint is derived
+// from, unless it is recreated. Containers using a recreated volume keep
+// their mount until they are restarted, new containers get the new one.
+func (d *webdavfsDriver) addVolume(name string, v *webdavfsVolume, recreate bool) error {
+	old, ok := d.volumes[name]
+	if ok {
+		if old.Mountpoint == v.Mountpoint {
+			return nil
+		}
+		if !recreate {
+			return fmt.Errorf("volume %s already exists with different options", name)
+		}
+		if d.mounting[old.Mountpoint] != nil {
+			return fmt.Errorf("volume %s is being mounted", name)
+		}
+		logrus.WithField("volume", name).Info("recreating volume")
+		if len(old.MountIDs) > 0 || old.previous != nil {
+			v.previous = old
+		}
+		// mounted through automount or waiting for its idle timeout
+		if len(old.MountIDs) == 0 && old.mounted {
+			if err := d.releaseMount(old); err != nil {
+				return err
+			}
+			old.mounted = false
+		}
+	}
+	v.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	// registered first, mounting reports the name of the volume
+	d.volumes[name] = v
+	if v.Automount {
+		if err := d.acquireMount(v); err != nil {
+			// unless another request replaced it while mounting
+			if d.volumes[name] == v && ok {
+				d.volumes[name] = old
+			} else if d.volumes[name] == v {
+				delete(d.volumes, name)
+			}
+			return err
+		}
+		v.mounted = true
+	}
+	d.saveState()
+	return nil
+}
+
+// mountpoint returns the mountpoint of the volume. Volumes with the same
+// options share a mountpoint, which is only mounted once.
+func (d *webdavfsDriver) mountpoint(v *webdavfsVolume) (string, error) {
+	c := *v
+	c.Mountpoint, c.MountIDs, c.CreatedAt = "", nil, ""
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d.root, fmt.Sprintf("%x", md5.Sum(data))), nil
+}
+
+func (d *webdavfsDriver) Remove(r *volume.RemoveRequest) (err error) {
 	logrus.WithField("method", "remove").Debugf("%#v", r)
+	defer logRequest("remove", r.Name, time.Now(), &err)
+	defer func() { d.audit.record("remove", r.Name, err, nil) }()
 
 	d.Lock()
 	defer d.Unlock()
 
 	v, ok := d.volumes[r.Name]
 	if !ok {
-		return logError("volume %s not found", r.Name)
+		return fmt.Errorf("volume %s not found", r.Name)
 	}
 
-	if v.connections != 0 {
-		return logError("volume %s is currently used by a container", r.Name)
+	if v.Protected && !v.allowRemove {
+		return fmt.Errorf("volume %s is protected, allow removing it through the admin API first", r.Name)
 	}
-	if err := os.RemoveAll(v.Mountpoint); err != nil {
-		return logError(err.Error())
+	if len(v.MountIDs) != 0 || v.previous != nil {
+		return fmt.Errorf("volume %s is currently used by a container", r.Name)
+	}
+	if d.mounting[v.Mountpoint] != nil {
+		return fmt.Errorf("volume %s is being mounted", r.Name)
+	}
+	for name, other := range d.volumes {
+		if other.BaseVolume == r.Name {
+			return fmt.Errorf("volume %s is the base volume of %s", r.Name, name)
+		}
+	}
+	if v.mounted {
+		// mounted by automount=true
+		if err := d.releaseMount(v); err != nil {
+			return err
+		}
+		v.mounted = false
 	}
 	delete(d.volumes, r.Name)
+	if d.retention > 0 {
+		// only the last removed volume of a name can be restored
+		old := d.trash[r.Name]
+		d.trash[r.Name] = &trashedVolume{Volume: v, RemovedAt: time.Now()}
+		if old != nil {
+			if err := d.destroyVolume(old.Volume); err != nil {
+				logrus.WithField("volume", r.Name).Error(err)
+			}
+		}
+		d.saveTrash()
+	} else if err := d.destroyVolume(v); err != nil {
+		d.volumes[r.Name] = v
+		return err
+	}
 	d.saveState()
 	return nil
 }
 
-func (d *webdavfsDriver) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
+// destroyVolume removes what is left of a removed volume, unless its
+// mountpoint is still used by another volume with the same options, also one
+// in the trash.
+func (d *webdavfsDriver) destroyVolume(v *webdavfsVolume) error {
+	for _, other := range d.volumes {
+		if other.Mountpoint == v.Mountpoint {
+			return nil
+		}
+	}
+	for _, t := range d.trash {
+		if t.Volume != v && t.Volume.Mountpoint == v.Mountpoint {
+			return nil
+		}
+	}
+	if v.Purge && !d.dryrun {
+		if err := d.purgeVolume(v); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(v.Mountpoint); err != nil {
+		return err
+	}
+	d.removeVolumeFiles(v)
+	return nil
+}
+
+// removeVolumeFiles removes the caches, overlay and generated configuration
+// files of a removed volume.
+func (d *webdavfsDriver) removeVolumeFiles(v *webdavfsVolume) {
+	base := filepath.Base(v.Mountpoint)
+	paths := d.cacheDirs(v)
+	if !isMounted(d.overlayDir(v, "lower")) {
+		paths = append(paths, filepath.Join(d.overlayPath, base))
+	}
+
+	// conf, credentials cache and certificates
+	matches, _ := filepath.Glob(filepath.Join(d.confPath, base+".*"))
+	paths = append(paths, matches...)
+
+	for _, path := range paths {
+		logrus.WithField("method", "remove").Debugf("removing %s", path)
+		if err := os.RemoveAll(path); err != nil {
+			logrus.WithField("method", "remove").Warn(err)
+		}
+	}
+}
+
+func (d *webdavfsDriver) Path(r *volume.PathRequest) (_ *volume.PathResponse, err error) {
 	logrus.WithField("method", "path").Debugf("%#v", r)
+	defer logRequest("path", r.Name, time.Now(), &err)
 
 	d.RLock()
 	defer d.RUnlock()
 
 	v, ok := d.volumes[r.Name]
 	if !ok {
-		return &volume.PathResponse{}, logError("volume %s not found", r.Name)
+		return &volume.PathResponse{}, fmt.Errorf("volume %s not found", r.Name)
 	}
 
 	return &volume.PathResponse{Mountpoint: v.Mountpoint}, nil
 }
 
-func (d *webdavfsDriver) Mount(r *volume.MountRequest) (*volume.MountResponse, error) {
+func (d *webdavfsDriver) Mount(r *volume.MountRequest) (_ *volume.MountResponse, err error) {
 	logrus.WithField("method", "mount").Debugf("%#v", r)
+	defer logRequest("mount", r.Name, time.Now(), &err)
+	defer func() { d.audit.record("mount", r.Name, err, map[string]interface{}{"id": r.ID}) }()
 
 	d.Lock()
 	defer d.Unlock()
 
 	v, ok := d.volumes[r.Name]
+	if ok {
+		// mounted or unmounted by another request meanwhile
+		d.waitMounting(v.Mountpoint)
+		v, ok = d.volumes[r.Name]
+	}
 	if !ok {
-		return &volume.MountResponse{}, logError("volume %s not found", r.Name)
+		return &volume.MountResponse{}, fmt.Errorf("volume %s not found", r.Name)
 	}
 
-	if v.connections == 0 {
-		fi, err := os.Lstat(v.Mountpoint)
-		if os.IsNotExist(err) {
-			if err := os.MkdirAll(v.Mountpoint, 0755); err != nil {
-				return &volume.MountResponse{}, logError(err.Error())
-			}
-		} else if err != nil {
-			return &volume.MountResponse{}, logError(err.Error())
+	if v.MountIDs[r.ID] {
+		logrus.WithField("method", "mount").Debugf("%s already mounted for %s", r.Name, r.ID)
+		if r.ID == "" {
+			return &volume.MountResponse{Mountpoint: v.Mountpoint}, nil
 		}
-
-		if fi != nil && !fi.IsDir() {
-			return &volume.MountResponse{}, logError("%v already exist and it's not a directory", v.Mountpoint)
+		return &volume.MountResponse{Mountpoint: d.containerDir(r.Name, r.ID)}, nil
+	}
+	if !v.mounted {
+		if err := d.acquireMount(v); err != nil {
+			return &volume.MountResponse{}, err
 		}
-
-		if err := d.mountVolume(v); err != nil {
-			return &volume.MountResponse{}, logError(err.Error())
+		v.mounted = true
+	}
+	dir, err := d.bindContainer(v, r.Name, r.ID)
+	if err != nil {
+		if len(v.MountIDs) == 0 {
+			d.releaseMount(v)
+			v.mounted = false
 		}
+		v.lastError, v.lastErrorAt = err.Error(), time.Now()
+		return &volume.MountResponse{}, err
+	}
+	if v.MountIDs == nil {
+		v.MountIDs = map[string]bool{}
 	}
-	v.connections++
+	v.MountIDs[r.ID] = true
+	v.lastMounted = time.Now()
+	d.saveState()
 
-	return &volume.MountResponse{Mountpoint: v.Mountpoint}, nil
+	return &volume.MountResponse{Mountpoint: dir}, nil
 }
 
-func (d *webdavfsDriver) Unmount(r *volume.UnmountRequest) error {
+func (d *webdavfsDriver) Unmount(r *volume.UnmountRequest) (err error) {
 	logrus.WithField("method", "unmount").Debugf("%#v", r)
+	defer logRequest("unmount", r.Name, time.Now(), &err)
+	defer func() { d.audit.record("unmount", r.Name, err, map[string]interface{}{"id": r.ID}) }()
 
 	d.Lock()
 	defer d.Unlock()
-	v, ok := d.volumes[r.Name]
+
+	return d.unmountContainer(r.Name, r.ID)
+}
+
+// unmountContainer releases the volume for the mount request id, the
+// volume is unmounted when no container uses it anymore.
+func (d *webdavfsDriver) unmountContainer(name, id string) error {
+	v, ok := d.volumes[name]
 	if !ok {
-		return logError("volume %s not found", r.Name)
+		return fmt.Errorf("volume %s not found", name)
 	}
 
-	v.connections--
+	// the container may still use a definition replaced by recreate=true
+	owner := v
+	for owner != nil && !owner.MountIDs[id] {
+		owner = owner.previous
+	}
+	if owner == nil {
+		logrus.WithField("method", "unmount").Debugf("%s not mounted for %s", name, id)
+		return nil
+	}
+	if err := d.unbindContainer(name, id); err != nil {
+		return err
+	}
+	delete(owner.MountIDs, id)
+	d.saveState()
 
-	if v.connections <= 0 {
-		if err := d.unmountVolume(v.Mountpoint); err != nil {
-			return logError(err.Error())
+	// volumes with an idle timeout are unmounted by watchIdle, definitions
+	// replaced by recreate=true right away
+	if len(owner.MountIDs) == 0 && owner.mounted && (owner != v || !owner.Automount && owner.IdleTimeout == 0) {
+		if err := d.releaseMount(owner); err != nil {
+			return err
+		}
+		owner.mounted = false
+	}
+	for p := v; p.previous != nil; {
+		if len(p.previous.MountIDs) == 0 {
+			p.previous = p.previous.previous
+		} else {
+			p = p.previous
 		}
-		v.connections = 0
 	}
 
 	return nil
 }
 
-func (d *webdavfsDriver) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
+func (d *webdavfsDriver) Get(r *volume.GetRequest) (_ *volume.GetResponse, err error) {
 	logrus.WithField("method", "get").Debugf("%#v", r)
+	defer logRequest("get", r.Name, time.Now(), &err)
 
 	d.Lock()
-	defer d.Unlock()
-
 	v, ok := d.volumes[r.Name]
 	if !ok {
-		return &volume.GetResponse{}, logError("volume %s not found", r.Name)
+		d.Unlock()
+		return &volume.GetResponse{}, fmt.Errorf("volume %s not found", r.Name)
+	}
+
+	status := d.volumeStatus(v)
+	// walked without the lock at most once a minute
+	var cacheDirs []string
+	if v.BaseVolume == "" {
+		if time.Since(v.cacheChecked) < time.Minute {
+			status["CacheBytes"] = v.cacheBytes
+		} else {
+			cacheDirs = d.cacheDirs(v)
+		}
+	}
+	if v.URL != "" {
+		status["URL"] = redactURL(v.URL)
+	}
+	from := v.url
+	if v.proxy != nil && v.proxy.failover != nil {
+		from = v.proxy.failover.current().String()
+	}
+	if from != "" && from != v.URL {
+		// mounted from a mirror
+		status["MountedFrom"] = redactURL(from)
+	}
+	status["PluginVersion"] = version
+	if v.lastError != "" {
+		status["LastError"] = v.lastError
+		status["LastErrorAt"] = v.lastErrorAt.UTC().Format(time.RFC3339)
+	}
+	if v.stats != nil {
+		for key, val := range v.stats.status() {
+			status[key] = val
+		}
+	}
+	// the mount and the server are queried without blocking other requests
+	mounted := v.mounted && !d.dryrun
+	c := *v
+	d.Unlock()
+
+	if cacheDirs != nil {
+		n := cacheUsage(cacheDirs)
+		status["CacheBytes"] = n
+		d.Lock()
+		v.cacheBytes, v.cacheChecked = n, time.Now()
+		d.Unlock()
+	}
+	if mounted {
+		if size, used, free, err := diskUsage(c.Mountpoint, 5*time.Second); err == nil {
+			status["SizeBytes"], status["UsedBytes"], status["FreeBytes"] = size, used, free
+		} else {
+			logrus.WithField("volume", r.Name).Debugf("statfs: %v", err)
+		}
+	}
+	if mounted && c.url != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		available, used, err := davQuota(ctx, &c, c.url)
+		cancel()
+		if err != nil {
+			logrus.WithField("volume", r.Name).Debugf("quota: %v", err)
+		}
+		if available >= 0 {
+			status["QuotaAvailableBytes"] = available
+		}
+		if used >= 0 {
+			status["QuotaUsedBytes"] = used
+		}
 	}
 
-	return &volume.GetResponse{Volume: &volume.Volume{Name: r.Name, Mountpoint: v.Mountpoint}}, nil
+	return &volume.GetResponse{Volume: &volume.Volume{Name: r.Name, Mountpoint: c.Mountpoint, Status: status}}, nil
 }
 
 func (d *webdavfsDriver) List() (*volume.ListResponse, error) {
@@ -256,97 +1324,433 @@ func (d *webdavfsDriver) List() (*volume.ListResponse, error) {
 
 	var vols []*volume.Volume
 	for name, v := range d.volumes {
-		vols = append(vols, &volume.Volume{Name: name, Mountpoint: v.Mountpoint})
+		vols = append(vols, &volume.Volume{Name: name, Mountpoint: v.Mountpoint, Status: d.volumeStatus(v)})
 	}
 	return &volume.ListResponse{Volumes: vols}, nil
 }
 
+// volumeStatus returns the status of the volume reported by Get and List.
+func (d *webdavfsDriver) volumeStatus(v *webdavfsVolume) map[string]interface{} {
+	status := map[string]interface{}{
+		"Mounted":     d.mounts[v.Mountpoint] != nil,
+		"Connections": len(v.MountIDs),
+		"Backend":     v.backend(),
+	}
+	if v.BaseVolume != "" {
+		delete(status, "Backend")
+		status["BaseVolume"] = v.BaseVolume
+	}
+	if v.CreatedAt != "" {
+		status["CreatedAt"] = v.CreatedAt
+	}
+	if len(v.Labels) > 0 {
+		status["Labels"] = v.Labels
+	}
+	return status
+}
+
+// parseLabels parses the labels option, "key=value" pairs separated by
+// commas.
+func parseLabels(val string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(val, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if key == "" {
+			return nil, fmt.Errorf("invalid label %q", pair)
+		}
+		if len(kv) == 2 {
+			labels[key] = strings.TrimSpace(kv[1])
+		} else {
+			labels[key] = ""
+		}
+	}
+	return labels, nil
+}
+
 func (d *webdavfsDriver) Capabilities() *volume.CapabilitiesResponse {
 	logrus.WithField("method", "capabilities").Debugf("")
 
 	return &volume.CapabilitiesResponse{Capabilities: volume.Capability{Scope: "local"}}
 }
 
+// mountVolume mounts the volume from its url, falling back to its mirrors in
+// order when that fails.
 func (d *webdavfsDriver) mountVolume(v *webdavfsVolume) error {
-	logrus.WithField("method", "mountVolume").Debugf("%#v", v)
+	defer d.startMounting(v.Mountpoint)()
 
-	u, err := url.Parse(v.URL)
+	var err error
+	for _, rawurl := range append([]string{v.URL}, v.Mirrors...) {
+		if err = d.mountURL(v, rawurl); err == nil {
+			return nil
+		}
+		if len(v.Mirrors) > 0 {
+			logrus.WithField("method", "mountVolume").Warnf("mounting from %s failed: %v", redactURL(rawurl), err)
+		}
+	}
+	return err
+}
+
+// maxMountRetryWait caps the time a mount waits between its retries, Docker
+// gives up on a mount request after 2 minutes.
+const maxMountRetryWait = time.Minute
+
+func (d *webdavfsDriver) mountURL(v *webdavfsVolume, rawurl string) error {
+	logrus.WithField("method", "mountVolume").Debug(v)
+
+	u, err := url.Parse(rawurl)
 	if err != nil {
 		log.Fatal(err)
 	}
-	logrus.WithField("method", "mountVolume").WithField("variable", "url").Debugf("%#v", u)
+	v.url = rawurl
+	logrus.WithField("method", "mountVolume").WithField("variable", "url").Debug(redactURL(rawurl))
+
+	// other volumes are served while credential helpers and Vault are
+	// asked, acquireMount waits for this one
+	c := *v
+	d.Unlock()
+	err = d.resolveCredentials(&c)
+	d.Lock()
+	if err != nil {
+		return err
+	}
+	v.username, v.password, v.token, v.clientSecret = c.username, c.password, c.token, c.clientSecret
+	v.credentials = credentialsDigest(v)
 
-	cmd := exec.Command("mount.webdavfs", fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path), v.Mountpoint)
+	target := fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path)
+	if d.dryrun {
+		// nothing is sent to the server, not even to sign in
+		return d.runMount(v, u, target)
+	}
+	if err := checkAuthScheme(v, target); err != nil {
+		return err
+	}
+	v.done = make(chan struct{})
+	fail := func(err error) error {
+		close(v.done)
+		v.done = nil
+		if v.proxy != nil {
+			v.proxy.Close()
+			v.proxy = nil
+		}
+		return err
+	}
+	if v.Krb5 {
+		if err := d.kinit(v); err != nil {
+			return fail(err)
+		}
+		go d.renewKrb5Ticket(v, v.done)
+	}
+	if needsDavProxy(v) {
+		p, err := d.startDavProxy(v, u)
+		if err != nil {
+			return fail(err)
+		}
+		v.proxy = p
+	}
+	// sent through the davProxy, which authenticates with the server
+	if err := checkAnonymous(v, target); err != nil {
+		return fail(err)
+	}
+	if v.CreatePath {
+		if err := createPath(v, target); err != nil {
+			return fail(err)
+		}
+	}
+	if v.proxy != nil {
+		target = v.proxy.URL()
+		// the helper authenticates with the proxy instead of the server
+		u = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path, User: v.proxy.Userinfo()}
+	}
 
-	if v.Conf != "" {
-		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("conf=%s", v.Conf))
+	if v.KeepaliveInterval > 0 {
+		go keepalive(v, v.proxy, time.Duration(v.KeepaliveInterval)*time.Second, v.done)
 	}
-	if v.UID != "" {
-		exec.Command("adduser", "-S", "-u", v.UID, v.UID).Run()
-		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("uid=%s", v.UID))
+
+	err = d.runMount(v, u, target)
+	delay := time.Second
+	if v.MountRetryDelay > 0 {
+		delay = time.Duration(v.MountRetryDelay) * time.Second
 	}
-	if v.GID != "" {
-		exec.Command("addgroup", "-S", "-g", v.GID, v.GID).Run()
-		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("gid=%s", v.GID))
+	var waited time.Duration
+	for i := 0; err != nil && i < v.MountRetries; i++ {
+		if waited+delay > maxMountRetryWait {
+			break
+		}
+		logrus.WithField("method", "mountVolume").Warnf("mount failed, retrying in %v: %v", delay, err)
+		// other volumes are served meanwhile, acquireMount waits for this one
+		d.Unlock()
+		time.Sleep(delay)
+		d.Lock()
+		waited += delay
+		delay *= 2
+		err = d.runMount(v, u, target)
 	}
-	if v.FileMode != "" {
-		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("file_mode=%s", v.FileMode))
+	if err != nil {
+		return fail(err)
 	}
-	if v.DirMode != "" {
-		cmd.Args = append(cmd.Args, "-o", fmt.Sprintf("dir_mode=%s", v.DirMode))
+	return nil
+}
+
+func (d *webdavfsDriver) runMount(v *webdavfsVolume, u *url.URL, target string) error {
+	m, ok := d.mounters[v.backend()]
+	if !ok {
+		return fmt.Errorf("backend %q not available", v.backend())
 	}
-	if v.Ro {
-		cmd.Args = append(cmd.Args, "-o", "ro")
+
+	ctx := context.Background()
+	timeout := time.Duration(v.ConnectTimeout+v.ReadTimeout) * time.Second
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
-	if v.Rw {
-		cmd.Args = append(cmd.Args, "-o", "rw")
+
+	var err error
+	if v.Overlay {
+		err = d.mountOverlay(ctx, m, v, u, target)
+	} else {
+		err = m.Mount(ctx, v, u, target, v.Mountpoint)
 	}
-	if v.Exec {
-		cmd.Args = append(cmd.Args, "-o", "exec")
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("mount timed out after %v", timeout)
 	}
-	if v.Suid {
-		cmd.Args = append(cmd.Args, "-o", "suid")
+	return err
+}
+
+func (d *webdavfsDriver) unmountVolume(v *webdavfsVolume) error {
+	defer d.startMounting(v.Mountpoint)()
+
+	m, ok := d.mounters[v.backend()]
+	if !ok {
+		return fmt.Errorf("backend %q not available", v.backend())
+	}
+	if v.Overlay {
+		if err := d.unmountOverlay(m, v); err != nil {
+			return err
+		}
+	} else if err := m.Unmount(v, v.Mountpoint); err != nil {
+		return err
 	}
-	if v.Grpid {
-		cmd.Args = append(cmd.Args, "-o", "grpid")
+	if v.done != nil {
+		close(v.done)
+		v.done = nil
 	}
-	if v.Netdev {
-		cmd.Args = append(cmd.Args, "-o", "_netdev")
+	if v.proxy != nil {
+		v.proxy.Close()
+		v.proxy = nil
 	}
+	return nil
+}
 
-	if u.User != nil {
-		username := u.User.Username()
-		password, _ := u.User.Password()
-		cmd.Stdin = strings.NewReader(fmt.Sprintf("%s\n%s", username, password))
-	} else if v.Username != "" {
-		cmd.Stdin = strings.NewReader(fmt.Sprintf("%s\n%s", v.Username, v.Password))
+func parseBool(key, val string) (bool, error) {
+	switch strings.ToLower(val) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("'%s' option malformed", key)
+	}
+	return b, nil
+}
+
+func parseSeconds(key, val string) (int, error) {
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("'%s' option malformed, expected seconds", key)
 	}
+	return n, nil
+}
 
-	logrus.Debug(cmd.Args)
-	return cmd.Run()
+// joinURLPath returns rawurl with p appended to its path.
+func joinURLPath(rawurl, p string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	clean := path.Clean("/" + p)
+	if clean != "/"+strings.Trim(p, "/") {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + clean + "/"
+	u.RawPath = ""
+	return u.String(), nil
 }
 
-func (d *webdavfsDriver) unmountVolume(target string) error {
-	cmd := fmt.Sprintf("umount %s", target)
-	logrus.Debug(cmd)
-	return exec.Command("sh", "-c", cmd).Run()
+// redactURL returns rawurl without its password.
+func redactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	if u.User != nil {
+		u.User = url.User(u.User.Username())
+	}
+	return u.String()
 }
 
-func logError(format string, args ...interface{}) error {
-	logrus.Errorf(format, args...)
-	return fmt.Errorf(format, args)
+// logRequest logs the outcome of a plugin request started at start.
+func logRequest(method, name string, start time.Time, err *error) {
+	entry := logrus.WithFields(logrus.Fields{
+		"method":   method,
+		"volume":   name,
+		"duration": time.Since(start).Seconds(),
+	})
+	if *err != nil {
+		entry.WithError(*err).Error("request failed")
+	} else {
+		entry.Debug("request done")
+	}
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatalf("%s: %v", key, err)
+	}
+	return d
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--version" {
+		fmt.Printf("docker-volume-webdavfs %s (commit %s, built %s)\n", version, commit, buildDate)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		socket := socketAddress
+		if len(os.Args) > 2 {
+			socket = os.Args[2]
+		}
+		if err := healthcheck(socket); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	debug := os.Getenv("DEBUG")
 	if ok, _ := strconv.ParseBool(debug); ok {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
+	switch os.Getenv("LOG_FORMAT") {
+	case "", "text":
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		log.Fatalf("unknown LOG_FORMAT %q", os.Getenv("LOG_FORMAT"))
+	}
+	if path := os.Getenv("LOG_FILE"); path != "" {
+		var err error
+		maxSize, maxBackups := 10, 5
+		if val := os.Getenv("LOG_MAX_SIZE"); val != "" {
+			if maxSize, err = strconv.Atoi(val); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if val := os.Getenv("LOG_MAX_BACKUPS"); val != "" {
+			if maxBackups, err = strconv.Atoi(val); err != nil {
+				log.Fatal(err)
+			}
+		}
+		f, err := openRotatingFile(path, int64(maxSize)<<20, envDuration("LOG_MAX_AGE", 0), maxBackups)
+		if err != nil {
+			log.Fatal(err)
+		}
+		logrus.SetOutput(io.MultiWriter(os.Stderr, f))
+	}
 
 	d, err := newwebdavfsDriver("/mnt")
 	if err != nil {
 		log.Fatal(err)
 	}
+	// driver wide defaults for volume options
+	for _, key := range []string{"tls_min_version", "tls_ciphers", "proxy", "user_agent"} {
+		if val := os.Getenv(strings.ToUpper(key)); val != "" {
+			d.defaults[key] = val
+		}
+	}
+
+	d.secretsFile = os.Getenv("SECRETS_FILE")
+
+	if ok, _ := strconv.ParseBool(os.Getenv("DRYRUN")); ok {
+		logrus.Warn("dry run, volumes are not actually mounted")
+		d.dryrun = true
+		for name := range d.mounters {
+			d.mounters[name] = &dryrunMounter{}
+		}
+	}
+
+	if d.mountNamespace, _ = strconv.ParseBool(os.Getenv("MOUNT_NAMESPACE")); d.mountNamespace {
+		if err := d.shareRoot(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if val := os.Getenv("MOUNT_FAILURE_THRESHOLD"); val != "" {
+		if d.breaker.threshold, err = strconv.Atoi(val); err != nil {
+			log.Fatal(err)
+		}
+	}
+	d.breaker.cooldown = envDuration("MOUNT_FAILURE_COOLDOWN", d.breaker.cooldown)
+
+	if d.retention = envDuration("REMOVE_RETENTION", 0); d.retention > 0 {
+		go d.emptyTrash(time.Minute)
+	}
+
+	d.webhook = os.Getenv("WEBHOOK_URL")
+
+	if path := os.Getenv("AUDIT_LOG"); path != "" {
+		if d.audit, err = openAuditLog(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if ok, _ := strconv.ParseBool(os.Getenv("DOCKER_EVENTS")); ok {
+		socket := os.Getenv("DOCKER_SOCKET")
+		if socket == "" {
+			socket = "/var/run/docker.sock"
+		}
+		go d.watchDockerEvents(socket, envDuration("DOCKER_EVENTS_DELAY", 10*time.Second))
+	}
+
+	if addr := os.Getenv("ADMIN_ADDRESS"); addr != "" {
+		go d.serveAdmin(addr)
+	}
+	if addr := os.Getenv("METRICS_ADDRESS"); addr != "" {
+		go d.listenMetrics(addr)
+	}
+	if addr := os.Getenv("HEALTH_ADDRESS"); addr != "" {
+		go d.listenHealth(addr)
+	}
+	if ok, _ := strconv.ParseBool(os.Getenv("PPROF")); ok {
+		addr := os.Getenv("PPROF_ADDRESS")
+		if addr == "" {
+			addr = "127.0.0.1:6060"
+		}
+		go servePprof(addr)
+	}
+
+	logrus.Infof("docker-volume-webdavfs %s (commit %s, built %s)", version, commit, buildDate)
+	d.adoptMounts()
+	go func() {
+		d.remountActive()
+		d.reconcileMounts()
+		d.watchOrphans(envDuration("ORPHAN_GC_INTERVAL", time.Hour))
+	}()
+	go d.automount()
+	go d.watchIdle(envDuration("IDLE_CHECK_INTERVAL", 10*time.Second))
+	go d.watchCredentials(envDuration("CREDENTIALS_CHECK_INTERVAL", time.Minute))
+
 	h := volume.NewHandler(d)
 	logrus.Infof("listening on %s", socketAddress)
 	logrus.Error(h.ServeUnix(socketAddress, 0))
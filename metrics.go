@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// mountLatencyBuckets are the upper bounds of the mount latency histogram
+// in seconds.
+var mountLatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type volumeMetrics struct {
+	mounts   uint64
+	unmounts uint64
+	failures uint64
+
+	// mount latency histogram, counts per bucket (not cumulative)
+	latency      []uint64
+	latencySum   float64
+	latencyCount uint64
+}
+
+// metrics are the counters of the driver exposed in the Prometheus text
+// format, gauges are taken from the volumes when scraped.
+type metrics struct {
+	sync.Mutex
+	volumes map[string]*volumeMetrics
+}
+
+func newMetrics() *metrics {
+	return &metrics{volumes: map[string]*volumeMetrics{}}
+}
+
+func (m *metrics) volume(name string) *volumeMetrics {
+	vm, ok := m.volumes[name]
+	if !ok {
+		vm = &volumeMetrics{latency: make([]uint64, len(mountLatencyBuckets)+1)}
+		m.volumes[name] = vm
+	}
+	return vm
+}
+
+// mounted records a mount of the volume which took d.
+func (m *metrics) mounted(name string, d time.Duration, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	vm := m.volume(name)
+	if err != nil {
+		vm.failures++
+		return
+	}
+	vm.mounts++
+	i := sort.SearchFloat64s(mountLatencyBuckets, d.Seconds())
+	vm.latency[i]++
+	vm.latencySum += d.Seconds()
+	vm.latencyCount++
+}
+
+// unmounted records an unmount of the volume.
+func (m *metrics) unmounted(name string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.volume(name).unmounts++
+}
+
+// volumeName returns the name of the volume v, v may also be a definition
+// replaced by recreate=true.
+func (d *webdavfsDriver) volumeName(v *webdavfsVolume) string {
+	for name, other := range d.volumes {
+		for p := other; p != nil; p = p.previous {
+			if p == v {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func promLabel(val string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(val)
+}
+
+// writeMetrics writes the metrics in the Prometheus text format.
+func (d *webdavfsDriver) writeMetrics(w io.Writer) {
+	d.RLock()
+	var names []string
+	for name := range d.volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# HELP webdavfs_volume_mounted Whether the volume is mounted.")
+	fmt.Fprintln(w, "# TYPE webdavfs_volume_mounted gauge")
+	for _, name := range names {
+		mounted := 0
+		if d.mounts[d.volumes[name].Mountpoint] != nil {
+			mounted = 1
+		}
+		fmt.Fprintf(w, "webdavfs_volume_mounted{volume=\"%s\"} %d\n", promLabel(name), mounted)
+	}
+	fmt.Fprintln(w, "# HELP webdavfs_volume_connections Number of containers using the volume.")
+	fmt.Fprintln(w, "# TYPE webdavfs_volume_connections gauge")
+	for _, name := range names {
+		fmt.Fprintf(w, "webdavfs_volume_connections{volume=\"%s\"} %d\n", promLabel(name), len(d.volumes[name].MountIDs))
+	}
+	for _, c := range []struct {
+		name, help string
+		val        func(*transferStats) int64
+	}{
+		{"webdavfs_requests_total", "Number of requests sent to the server.", func(s *transferStats) int64 { return atomic.LoadInt64(&s.requests) }},
+		{"webdavfs_request_errors_total", "Number of requests to the server which failed.", func(s *transferStats) int64 { return atomic.LoadInt64(&s.errors) }},
+		{"webdavfs_uploaded_bytes_total", "Bytes uploaded to the server.", func(s *transferStats) int64 { return atomic.LoadInt64(&s.uploaded) }},
+		{"webdavfs_downloaded_bytes_total", "Bytes downloaded from the server.", func(s *transferStats) int64 { return atomic.LoadInt64(&s.downloaded) }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+		for _, name := range names {
+			if s := d.volumes[name].stats; s != nil {
+				fmt.Fprintf(w, "%s{volume=\"%s\"} %d\n", c.name, promLabel(name), c.val(s))
+			}
+		}
+	}
+	d.RUnlock()
+
+	d.metrics.Lock()
+	defer d.metrics.Unlock()
+
+	names = names[:0]
+	for name := range d.metrics.volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, c := range []struct {
+		name, help string
+		val        func(*volumeMetrics) uint64
+	}{
+		{"webdavfs_mounts_total", "Number of mounts of the volume.", func(vm *volumeMetrics) uint64 { return vm.mounts }},
+		{"webdavfs_unmounts_total", "Number of unmounts of the volume.", func(vm *volumeMetrics) uint64 { return vm.unmounts }},
+		{"webdavfs_mount_failures_total", "Number of failed mounts of the volume.", func(vm *volumeMetrics) uint64 { return vm.failures }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{volume=\"%s\"} %d\n", c.name, promLabel(name), c.val(d.metrics.volumes[name]))
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP webdavfs_mount_duration_seconds Time mounting the volume took.")
+	fmt.Fprintln(w, "# TYPE webdavfs_mount_duration_seconds histogram")
+	for _, name := range names {
+		vm := d.metrics.volumes[name]
+		var cumulative uint64
+		for i, le := range mountLatencyBuckets {
+			cumulative += vm.latency[i]
+			fmt.Fprintf(w, "webdavfs_mount_duration_seconds_bucket{volume=\"%s\",le=\"%g\"} %d\n", promLabel(name), le, cumulative)
+		}
+		fmt.Fprintf(w, "webdavfs_mount_duration_seconds_bucket{volume=\"%s\",le=\"+Inf\"} %d\n", promLabel(name), vm.latencyCount)
+		fmt.Fprintf(w, "webdavfs_mount_duration_seconds_sum{volume=\"%s\"} %g\n", promLabel(name), vm.latencySum)
+		fmt.Fprintf(w, "webdavfs_mount_duration_seconds_count{volume=\"%s\"} %d\n", promLabel(name), vm.latencyCount)
+	}
+}
+
+// serveMetrics handles GET /metrics.
+func (d *webdavfsDriver) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	d.writeMetrics(w)
+}
+
+// listen listens on addr, a TCP address or unix:<path> for a unix socket.
+func listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		os.Remove(path)
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenMetrics serves only the metrics on addr.
+func (d *webdavfsDriver) listenMetrics(addr string) {
+	l, err := listen(addr)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", d.serveMetrics)
+
+	logrus.Infof("metrics listening on %s", addr)
+	logrus.Error(http.Serve(l, mux))
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// sharedMount is a mount shared by the volumes with the same options, which
+// all have its mountpoint.
+type sharedMount struct {
+	// volume the share was mounted for
+	v           *webdavfsVolume
+	connections int
+}
+
+// startMounting marks dir as being mounted or unmounted until the returned
+// func is called, as the driver lock is released while waiting to retry or
+// synchronising.
+func (d *webdavfsDriver) startMounting(dir string) func() {
+	done := make(chan struct{})
+	d.mounting[dir] = done
+	return func() {
+		delete(d.mounting, dir)
+		close(done)
+	}
+}
+
+// waitMounting waits until no other request is mounting or unmounting dir.
+func (d *webdavfsDriver) waitMounting(dir string) {
+	for done := d.mounting[dir]; done != nil; done = d.mounting[dir] {
+		d.Unlock()
+		<-done
+		d.Lock()
+	}
+}
+
+// acquireMount mounts the mountpoint of the volume, unless it is already
+// mounted for another volume with the same options.
+func (d *webdavfsDriver) acquireMount(v *webdavfsVolume) error {
+	d.waitMounting(v.Mountpoint)
+	if m := d.mounts[v.Mountpoint]; m != nil {
+		m.connections++
+		return nil
+	}
+
+	fi, err := os.Lstat(v.Mountpoint)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(v.Mountpoint, 0755); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if fi != nil && !fi.IsDir() {
+		return fmt.Errorf("%v already exist and it's not a directory", v.Mountpoint)
+	}
+
+	start := time.Now()
+	if v.BaseVolume != "" {
+		err = d.mountSubdir(v)
+	} else {
+		if err = d.breaker.check(v.URL); err == nil {
+			err = d.mountVolume(v)
+			d.breaker.record(v.URL, err)
+		}
+	}
+	name := d.volumeName(v)
+	d.metrics.mounted(name, time.Since(start), err)
+	if err != nil {
+		v.lastError, v.lastErrorAt = err.Error(), time.Now()
+		d.notifyMountFailure(name, v, err)
+		return err
+	}
+	v.lastError = ""
+	d.mounts[v.Mountpoint] = &sharedMount{v: v, connections: 1}
+	return nil
+}
+
+// releaseMount unmounts the mountpoint of the volume once no other volume
+// uses it anymore.
+func (d *webdavfsDriver) releaseMount(v *webdavfsVolume) error {
+	m := d.mounts[v.Mountpoint]
+	if m == nil {
+		return nil
+	}
+	m.connections--
+	if m.connections > 0 {
+		return nil
+	}
+
+	var err error
+	if m.v.BaseVolume != "" {
+		err = d.unmountSubdir(m.v)
+	} else {
+		err = d.unmountVolume(m.v)
+	}
+	if err != nil {
+		m.v.lastError, m.v.lastErrorAt = "unmount: "+err.Error(), time.Now()
+		return err
+	}
+	d.metrics.unmounted(d.volumeName(m.v))
+	delete(d.mounts, v.Mountpoint)
+	return nil
+}
+
+// adoptMounts takes over the mountpoints which are still mounted when the
+// driver starts, e.g. after the plugin restarted uncleanly, instead of
+// failing to mount them again.
+func (d *webdavfsDriver) adoptMounts() {
+	d.Lock()
+	defer d.Unlock()
+
+	// base volumes first, their subdirectory volumes hold on to them
+	for _, subdirs := range []bool{false, true} {
+		for name, v := range d.volumes {
+			if (v.BaseVolume != "") != subdirs || d.mounts[v.Mountpoint] != nil || !isMounted(v.Mountpoint) {
+				continue
+			}
+			if _, err := os.Stat(v.Mountpoint); err != nil {
+				// the mount helper is gone
+				logrus.WithField("volume", name).Warnf("removing stale mount: %v", err)
+				d.unmountStale(name, v)
+				continue
+			}
+			if base, ok := d.volumes[v.BaseVolume]; ok && d.mounts[base.Mountpoint] == nil {
+				logrus.WithField("volume", name).Warn("removing mount of unmounted base volume")
+				d.unmountStale(name, v)
+				continue
+			}
+			if v.BaseVolume == "" && needsDavProxy(v) {
+				// the helper talks to the davProxy of the previous run
+				logrus.WithField("volume", name).Warn("removing mount without its proxy")
+				d.unmountStale(name, v)
+				continue
+			}
+
+			logrus.WithField("volume", name).Info("adopting existing mount")
+			d.mounts[v.Mountpoint] = &sharedMount{v: v}
+			if base, ok := d.volumes[v.BaseVolume]; ok {
+				d.mounts[base.Mountpoint].connections++
+			}
+			if v.BaseVolume == "" {
+				d.resumeMount(name, v)
+			}
+		}
+	}
+	for _, v := range d.volumes {
+		if m := d.mounts[v.Mountpoint]; m != nil && len(v.MountIDs) > 0 {
+			m.connections++
+			v.mounted = true
+		}
+	}
+}
+
+// unmountStale unmounts a mountpoint the driver doesn't adopt, and the
+// container mounts of the volume on it, so they are mounted again.
+func (d *webdavfsDriver) unmountStale(name string, v *webdavfsVolume) {
+	for id := range v.MountIDs {
+		if dir := d.containerDir(name, id); id != "" && isMounted(dir) {
+			umount(dir)
+		}
+	}
+	umount(v.Mountpoint)
+}
+
+// resumeMount starts what runs alongside the mount helper of an adopted
+// mount again.
+func (d *webdavfsDriver) resumeMount(name string, v *webdavfsVolume) {
+	// mounts from mirrors go through a davProxy and aren't adopted
+	v.url = v.URL
+	if err := d.resolveCredentials(v); err != nil {
+		logrus.WithField("volume", name).Error(err)
+	} else {
+		v.credentials = credentialsDigest(v)
+	}
+	v.done = make(chan struct{})
+	if v.Krb5 {
+		if err := d.kinit(v); err != nil {
+			logrus.WithField("volume", name).Error(err)
+		}
+		go d.renewKrb5Ticket(v, v.done)
+	}
+	if v.KeepaliveInterval > 0 {
+		go keepalive(v, nil, time.Duration(v.KeepaliveInterval)*time.Second, v.done)
+	}
+}
+
+// remountActive mounts the volumes containers were using when the driver
+// stopped again, so containers which survived a restart of the plugin don't
+// keep pointing at empty directories.
+func (d *webdavfsDriver) remountActive() {
+	d.Lock()
+	defer d.Unlock()
+
+	for name, v := range d.volumes {
+		if len(v.MountIDs) == 0 || v.mounted {
+			continue
+		}
+
+		logrus.WithField("volume", name).Info("remounting volume used before restart")
+		if err := d.acquireMount(v); err != nil {
+			logrus.WithField("volume", name).Error(err)
+			continue
+		}
+		v.mounted = true
+		for id := range v.MountIDs {
+			if id == "" || isMounted(d.containerDir(name, id)) {
+				continue
+			}
+			if _, err := d.bindContainer(v, name, id); err != nil {
+				logrus.WithField("volume", name).Error(err)
+			}
+		}
+	}
+}
+
+// reconcileMounts compares the state with the mounts of the driver after it
+// started: volumes whose mountpoint isn't mounted aren't used by any
+// container anymore, and mounts below the volumes root without a volume are
+// reported.
+func (d *webdavfsDriver) reconcileMounts() {
+	d.Lock()
+	defer d.Unlock()
+
+	dirs, err := mountPoints()
+	if err != nil {
+		logrus.WithField("method", "reconcile").Error(err)
+		return
+	}
+	mounted := map[string]bool{}
+	for _, dir := range dirs {
+		mounted[dir] = true
+	}
+
+	known := map[string]bool{}
+	var active, cleared int
+	for name, v := range d.volumes {
+		known[v.Mountpoint] = true
+		for id := range v.MountIDs {
+			known[d.containerDir(name, id)] = true
+		}
+		if len(v.MountIDs) == 0 {
+			continue
+		}
+		// mode=sync volumes are plain directories, not mounts
+		if mounted[v.Mountpoint] || d.mounts[v.Mountpoint] != nil || d.dryrun {
+			active++
+			continue
+		}
+		logrus.WithField("volume", name).Warnf("not mounted, clearing %d mount ids", len(v.MountIDs))
+		v.MountIDs = nil
+		cleared++
+	}
+	d.saveState()
+
+	var unknown int
+	for _, dir := range dirs {
+		if strings.HasPrefix(dir, d.root+"/") && !known[dir] {
+			logrus.WithField("method", "reconcile").Warnf("%s is mounted but belongs to no volume", dir)
+			unknown++
+		}
+	}
+	logrus.WithField("method", "reconcile").Infof("%d volumes in use, %d cleared, %d unknown mounts", active, cleared, unknown)
+}
+
+// collectOrphans removes the directories below the volumes root which belong
+// to no volume and aren't mounted, left behind by failed creates and older
+// versions of the driver. Only empty directories are removed.
+func (d *webdavfsDriver) collectOrphans() {
+	known := map[string]bool{filepath.Join(d.root, "containers"): true}
+	for name, v := range d.volumes {
+		known[v.Mountpoint] = true
+		known[filepath.Join(d.root, "containers", name)] = true
+		for id := range v.MountIDs {
+			known[d.containerDir(name, id)] = true
+		}
+	}
+	for _, t := range d.trash {
+		known[t.Volume.Mountpoint] = true
+	}
+
+	dirs, _ := filepath.Glob(filepath.Join(d.root, "*"))
+	containers, _ := filepath.Glob(filepath.Join(d.root, "containers", "*", "*"))
+	for _, dir := range append(containers, dirs...) {
+		if known[dir] || isMounted(dir) {
+			continue
+		}
+		if err := os.Remove(dir); err == nil {
+			logrus.WithField("method", "gc").Infof("removed orphaned %s", dir)
+		}
+	}
+	// container directories of removed volumes, once empty
+	names, _ := filepath.Glob(filepath.Join(d.root, "containers", "*"))
+	for _, dir := range names {
+		if !known[dir] {
+			os.Remove(dir)
+		}
+	}
+}
+
+// watchOrphans periodically removes orphaned directories.
+func (d *webdavfsDriver) watchOrphans(interval time.Duration) {
+	for {
+		d.Lock()
+		d.collectOrphans()
+		d.Unlock()
+		time.Sleep(interval)
+	}
+}
+
+// automount mounts the volumes with automount=true when the driver starts.
+func (d *webdavfsDriver) automount() {
+	d.Lock()
+	defer d.Unlock()
+
+	for name, v := range d.volumes {
+		if !v.Automount || v.mounted {
+			continue
+		}
+		if err := d.acquireMount(v); err != nil {
+			logrus.WithField("volume", name).Error(err)
+			continue
+		}
+		v.mounted = true
+	}
+}
+
+// watchIdle periodically unmounts the volumes with an idle timeout which
+// haven't been used by any container for that long.
+func (d *webdavfsDriver) watchIdle(interval time.Duration) {
+	for range time.Tick(interval) {
+		d.Lock()
+		for name, v := range d.volumes {
+			if v.IdleTimeout == 0 || !v.mounted || len(v.MountIDs) > 0 {
+				v.idleSince = time.Time{}
+				continue
+			}
+			if v.idleSince.IsZero() {
+				v.idleSince = time.Now()
+				continue
+			}
+			if time.Since(v.idleSince) < time.Duration(v.IdleTimeout)*time.Second {
+				continue
+			}
+
+			logrus.WithField("volume", name).Info("idle, unmounting")
+			if err := d.releaseMount(v); err != nil {
+				logrus.WithField("volume", name).Error(err)
+				continue
+			}
+			v.mounted = false
+			v.idleSince = time.Time{}
+		}
+		d.Unlock()
+	}
+}
+
+// mountSubdir bind mounts the subdirectory of the base volume of v on its
+// mountpoint, mounting the base volume first.
+func (d *webdavfsDriver) mountSubdir(v *webdavfsVolume) error {
+	base, ok := d.volumes[v.BaseVolume]
+	if !ok {
+		return fmt.Errorf("base volume %s not found", v.BaseVolume)
+	}
+	if err := d.acquireMount(base); err != nil {
+		return err
+	}
+
+	src := filepath.Join(base.Mountpoint, v.Subdir)
+	if d.dryrun {
+		logrus.WithField("method", "dryrun").Infof("mount --bind %s %s", src, v.Mountpoint)
+		return nil
+	}
+	if err := os.MkdirAll(src, 0755); err != nil {
+		d.releaseMount(base)
+		return err
+	}
+	if out, err := exec.Command("mount", "--bind", src, v.Mountpoint).CombinedOutput(); err != nil {
+		d.releaseMount(base)
+		return fmt.Errorf("mount --bind %s: %v: %s", src, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (d *webdavfsDriver) unmountSubdir(v *webdavfsVolume) error {
+	if !d.dryrun {
+		if err := umount(v.Mountpoint); err != nil {
+			return err
+		}
+	}
+	base, ok := d.volumes[v.BaseVolume]
+	if !ok {
+		return nil
+	}
+	return d.releaseMount(base)
+}
+
+// containerDir returns the directory the volume is bind mounted on for the
+// mount request with the id, so every container gets its own mount which
+// can be cleaned up without affecting the others.
+func (d *webdavfsDriver) containerDir(name, id string) string {
+	return filepath.Join(d.root, "containers", name, id)
+}
+
+// bindContainer bind mounts the mountpoint of the volume for the mount
+// request with the id and returns where.
+func (d *webdavfsDriver) bindContainer(v *webdavfsVolume, name, id string) (string, error) {
+	if id == "" {
+		return v.Mountpoint, nil
+	}
+	dir := d.containerDir(name, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if d.dryrun {
+		logrus.WithField("method", "dryrun").Infof("mount --bind %s %s", v.Mountpoint, dir)
+		return dir, nil
+	}
+	if out, err := exec.Command("mount", "--bind", v.Mountpoint, dir).CombinedOutput(); err != nil {
+		os.Remove(dir)
+		return "", fmt.Errorf("mount --bind %s: %v: %s", v.Mountpoint, err, bytes.TrimSpace(out))
+	}
+	return dir, nil
+}
+
+// unbindContainer removes the bind mount of the mount request with the id.
+func (d *webdavfsDriver) unbindContainer(name, id string) error {
+	if id == "" {
+		return nil
+	}
+	dir := d.containerDir(name, id)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	// not mounted anymore after a restart of the plugin
+	if !d.dryrun && isMounted(dir) {
+		if err := umount(dir); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(dir); err != nil {
+		return err
+	}
+	// only removed once no other container uses the volume
+	os.Remove(filepath.Dir(dir))
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// nextcloudURL returns the WebDAV url of the files of the volume user on the
+// Nextcloud server at base.
+func nextcloudURL(v *webdavfsVolume, base *url.URL) (string, error) {
+	username, _ := credentials(v, base)
+	if username == "" {
+		return "", fmt.Errorf("'nextcloud=true' requires a username")
+	}
+
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/remote.php/dav/files/" + username + "/"
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// publicShareURL returns the WebDAV url of public shares on the Nextcloud or
+// ownCloud server at base, which authenticates with the share token as
+// username and the share password.
+func publicShareURL(base *url.URL) string {
+	u := *base
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/public.php/webdav/"
+	u.RawPath = ""
+	return u.String()
+}
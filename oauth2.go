@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+type oauth2Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// fetchOAuth2Token obtains an access token using the client credentials grant.
+func fetchOAuth2Token(v *webdavfsVolume) (*oauth2Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest("POST", v.OAuth2TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(v.OAuth2ClientID), url.QueryEscape(v.clientSecret))
+
+	// the token endpoint is reached like the server of the volume
+	client, err := newClient(v)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	t := &oauth2Token{}
+	if err := json.NewDecoder(resp.Body).Decode(t); err != nil {
+		return nil, err
+	}
+	if t.AccessToken == "" {
+		return nil, fmt.Errorf("token endpoint returned no access_token")
+	}
+	return t, nil
+}
+
+// refreshOAuth2Token keeps the Authorization header of p current, fetching a
+// new token shortly before the previous one expires, until p is closed.
+func refreshOAuth2Token(v *webdavfsVolume, p *davProxy, t *oauth2Token) {
+	for {
+		p.setHeader("Authorization", "Bearer "+t.AccessToken)
+
+		delay := time.Hour
+		if t.ExpiresIn > 0 {
+			// renew when 80% of the token lifetime has passed
+			delay = time.Duration(t.ExpiresIn) * time.Second * 4 / 5
+		}
+
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-time.After(delay):
+			}
+
+			next, err := fetchOAuth2Token(v)
+			if err == nil {
+				t = next
+				break
+			}
+			logrus.WithField("method", "refreshOAuth2Token").Error(err)
+			delay = 30 * time.Second
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// overlayDir returns a directory of the overlay of the volume: lower (where
+// the share is mounted), upper (local changes) or work.
+func (d *webdavfsDriver) overlayDir(v *webdavfsVolume, name string) string {
+	return filepath.Join(d.overlayPath, filepath.Base(v.Mountpoint), name)
+}
+
+// mountOverlay mounts the share read-only and stacks a writable overlayfs on
+// top of it on the volume mountpoint, so containers can write without
+// modifying the share.
+func (d *webdavfsDriver) mountOverlay(ctx context.Context, m mounter, v *webdavfsVolume, u *url.URL, target string) error {
+	lower, upper, work := d.overlayDir(v, "lower"), d.overlayDir(v, "upper"), d.overlayDir(v, "work")
+	for _, dir := range []string{lower, upper, work} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := m.Mount(ctx, v, u, target, lower); err != nil {
+		return err
+	}
+	if d.dryrun {
+		return nil
+	}
+
+	cmd := exec.Command("mount", "-t", "overlay", "overlay",
+		"-o", fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lower, upper, work), v.Mountpoint)
+	logrus.Debug(cmd.Args)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		m.Unmount(v, lower)
+		return fmt.Errorf("overlay: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (d *webdavfsDriver) unmountOverlay(m mounter, v *webdavfsVolume) error {
+	if d.dryrun {
+		return m.Unmount(v, d.overlayDir(v, "lower"))
+	}
+	if err := umount(v.Mountpoint); err != nil {
+		return err
+	}
+	return m.Unmount(v, d.overlayDir(v, "lower"))
+}
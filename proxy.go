@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// davProxy is a loopback HTTP proxy the mount helper talks to instead of the
+// WebDAV server, so the driver can rewrite requests while the volume is
+// mounted, e.g. to rotate an access token. The helper authenticates with a
+// random per-mount user and secret, the proxy sends the credentials of the
+// volume instead, so other users of the host can't use the proxy.
+type davProxy struct {
+	sync.Mutex
+
+	user     string
+	secret   string
+	target   *url.URL
+	header   http.Header
+	upload   *rateLimiter
+	download *rateLimiter
+	wire     *os.File
+	failover *failoverTransport
+	listener net.Listener
+	server   *http.Server
+	done     chan struct{}
+}
+
+// needsDavProxy reports whether the mount helper has to talk to the server
+// through a davProxy, for features davfs2 doesn't support itself.
+func needsDavProxy(v *webdavfsVolume) bool {
+	return v.OAuth2TokenURL != "" ||
+		v.UserAgent != "" ||
+		isSocksProxy(v.Proxy) ||
+		v.BindAddress != "" ||
+		v.BindInterface != "" ||
+		v.IPVersion == "4" || v.IPVersion == "6" ||
+		v.BWLimit != "" ||
+		v.HTTPDebug ||
+		len(v.Mirrors) > 0 ||
+		v.Stats ||
+		// the helpers negotiate TLS on their own
+		v.TLSMinVersion != "" || v.TLSCiphers != "" ||
+		// the cookies of the sign in expire and are refreshed
+		v.Auth == "sharepoint" && v.FedAuth == "" ||
+		// webdavfs only takes credentials on its command line, where any
+		// process can read them, it gets the per-mount secret of the
+		// davProxy there instead of those of the server
+		v.backend() == "fuse-webdavfs"
+}
+
+// startDavProxy starts the davProxy the volume is mounted through.
+func (d *webdavfsDriver) startDavProxy(v *webdavfsVolume, u *url.URL) (*davProxy, error) {
+	var t *oauth2Token
+	if v.OAuth2TokenURL != "" {
+		var err error
+		if t, err = fetchOAuth2Token(v); err != nil {
+			return nil, fmt.Errorf("oauth2: %v", err)
+		}
+	}
+
+	var transport http.RoundTripper
+	transport, err := newTransport(v)
+	if err != nil {
+		return nil, err
+	}
+	var failover *failoverTransport
+	if len(v.Mirrors) > 0 {
+		if failover, err = newFailoverTransport(transport, v, u); err != nil {
+			return nil, err
+		}
+		transport = failover
+	}
+	if v.stats == nil {
+		v.stats = &transferStats{}
+	}
+	transport = &statsTransport{next: transport, stats: v.stats}
+	var wire *os.File
+	if v.HTTPDebug {
+		if wire, err = d.openWireLog(v); err != nil {
+			return nil, err
+		}
+		if transport, err = newWireTransport(transport, wire, v); err != nil {
+			wire.Close()
+			return nil, err
+		}
+	}
+
+	p, err := newDavProxy(&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}, transport)
+	if err != nil {
+		if wire != nil {
+			wire.Close()
+		}
+		return nil, err
+	}
+	p.wire = wire
+	p.failover = failover
+	if v.UserAgent != "" {
+		p.setHeader("User-Agent", v.UserAgent)
+	}
+	if v.BWLimit != "" {
+		rate, _ := parseRate(v.BWLimit)
+		p.limit(rate)
+	}
+	p.setCredentials(v, u)
+	if v.Auth == "sharepoint" {
+		cookies, err := sharepointCookies(v)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.setHeader("Cookie", cookies)
+		if v.FedAuth == "" {
+			go d.refreshSharepointCookies(v, p)
+		}
+	}
+	if t != nil {
+		go refreshOAuth2Token(v, p, t)
+	}
+	return p, nil
+}
+
+// setCredentials makes the proxy authenticate with the resolved credentials
+// of the volume, also to rotate them while mounted.
+func (p *davProxy) setCredentials(v *webdavfsVolume, u *url.URL) {
+	if username, password := credentials(v, u); username != "" && v.Auth != "sharepoint" {
+		p.setHeader("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	}
+	if v.token != "" {
+		p.setHeader("Authorization", "Bearer "+v.token)
+	}
+}
+
+// needsHelperAuth reports whether the mount helper itself has to
+// authenticate with the server, which the davProxy can't do for it.
+func needsHelperAuth(v *webdavfsVolume) bool {
+	return v.Krb5 || v.Auth == "digest" || v.Auth == "ntlm"
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func newDavProxy(target *url.URL, transport http.RoundTripper) (*davProxy, error) {
+	user, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &davProxy{
+		user:     user,
+		secret:   secret,
+		target:   target,
+		header:   http.Header{},
+		listener: l,
+		done:     make(chan struct{}),
+	}
+	rp := &httputil.ReverseProxy{
+		Director:       p.director,
+		Transport:      transport,
+		ModifyResponse: p.modifyResponse,
+	}
+	p.server = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="webdavfs"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		rp.ServeHTTP(w, r)
+	})}
+
+	go func() {
+		if err := p.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			logrus.WithField("proxy", p.URL()).Error(err)
+		}
+	}()
+	return p, nil
+}
+
+// URL returns the address the mount helper should use.
+func (p *davProxy) URL() string {
+	return "http://" + p.listener.Addr().String() + p.target.Path
+}
+
+// Userinfo returns the credentials the mount helper has to authenticate
+// with.
+func (p *davProxy) Userinfo() *url.Userinfo {
+	return url.UserPassword(p.user, p.secret)
+}
+
+// probe sends an OPTIONS request to the server through the proxy, reusing
+// the connections of the mount helper.
+func (p *davProxy) probe() error {
+	req, err := http.NewRequest("OPTIONS", p.URL(), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.user, p.secret)
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	logrus.WithField("method", "keepalive").Debugf("%s: %s", redactURL(p.target.String()), resp.Status)
+	return nil
+}
+
+func (p *davProxy) authorized(r *http.Request) bool {
+	user, secret, ok := r.BasicAuth()
+	return ok && subtle.ConstantTimeCompare([]byte(user), []byte(p.user)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(p.secret)) == 1
+}
+
+// setHeader sets a header sent with every proxied request.
+func (p *davProxy) setHeader(key, value string) {
+	p.Lock()
+	defer p.Unlock()
+	p.header.Set(key, value)
+}
+
+func (p *davProxy) director(r *http.Request) {
+	r.URL.Scheme = p.target.Scheme
+	r.URL.Host = p.target.Host
+	r.Host = p.target.Host
+	// the credentials of the helper are only for the proxy
+	r.Header.Del("Authorization")
+
+	// MOVE and COPY carry the absolute target URL in a header.
+	if dest := r.Header.Get("Destination"); dest != "" {
+		if u, err := url.Parse(dest); err == nil {
+			u.Scheme = p.target.Scheme
+			u.Host = p.target.Host
+			r.Header.Set("Destination", u.String())
+		}
+	}
+
+	if p.upload != nil && r.Body != nil {
+		r.Body = &limitedReader{ReadCloser: r.Body, limiter: p.upload}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	for key, values := range p.header {
+		r.Header[key] = values
+	}
+}
+
+func (p *davProxy) modifyResponse(resp *http.Response) error {
+	if p.download != nil {
+		resp.Body = &limitedReader{ReadCloser: resp.Body, limiter: p.download}
+	}
+	return nil
+}
+
+// limit caps the upload and download throughput to rate bytes per second
+// each.
+func (p *davProxy) limit(rate int64) {
+	p.upload = newRateLimiter(rate)
+	p.download = newRateLimiter(rate)
+}
+
+// Close stops the proxy and any goroutine waiting on done.
+func (p *davProxy) Close() error {
+	close(p.done)
+	err := p.server.Close()
+	if p.wire != nil {
+		p.wire.Close()
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseRate parses a rate in bytes per second with an optional K, M or G
+// suffix, e.g. 10M.
+func parseRate(val string) (int64, error) {
+	if val == "" {
+		return 0, fmt.Errorf("empty rate")
+	}
+	mult := int64(1)
+	switch strings.ToUpper(val[len(val)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		val = val[:len(val)-1]
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", val)
+	}
+	return n * mult, nil
+}
+
+// rateLimiter paces the bytes transferred to rate bytes per second.
+type rateLimiter struct {
+	sync.Mutex
+
+	rate int64
+	next time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// wait blocks until n more bytes may be transferred.
+func (l *rateLimiter) wait(n int) {
+	l.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	delay := l.next.Sub(now)
+	l.Unlock()
+
+	time.Sleep(delay)
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	limiter *rateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		val  string
+		want int64
+		ok   bool
+	}{
+		{"1", 1, true},
+		{"1024", 1024, true},
+		{"10K", 10 << 10, true},
+		{"10k", 10 << 10, true},
+		{"10M", 10 << 20, true},
+		{"2G", 2 << 30, true},
+		{"", 0, false},
+		{"M", 0, false},
+		{"0", 0, false},
+		{"-1M", 0, false},
+		{"1.5M", 0, false},
+		{"10T", 0, false},
+		{"ten", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseRate(tt.val)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseRate(%q) = %d, %v, want %d, ok %v", tt.val, got, err, tt.want, tt.ok)
+		}
+	}
+}
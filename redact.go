@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const redacted = "[redacted]"
+
+// secretOptions are the options whose values must never be logged.
+var secretOptions = map[string]bool{
+	"password":             true,
+	"encrypt_password":     true,
+	"bearer_token":         true,
+	"share_token":          true,
+	"fedauth":              true,
+	"rtfa":                 true,
+	"oauth2_client_secret": true,
+	"headers":              true,
+	"conf_content":         true,
+	"client_key":           true,
+}
+
+// redactOptions returns a copy of volume options safe for logging.
+func redactOptions(options map[string]string) map[string]string {
+	if options == nil {
+		return nil
+	}
+	safe := make(map[string]string, len(options))
+	for key, val := range options {
+		switch {
+		case secretOptions[key] && val != "":
+			val = redacted
+		case key == "url":
+			// may list mirrors, separated by commas
+			urls := strings.Split(val, ",")
+			for i, rawurl := range urls {
+				urls[i] = redactURL(rawurl)
+			}
+			val = strings.Join(urls, ",")
+		case key == "proxy":
+			val = redactURL(val)
+		case key == "mirrors":
+			val = redactURLs(val)
+		}
+		safe[key] = val
+	}
+	return safe
+}
+
+// redactURLs redacts a space separated list of urls.
+func redactURLs(val string) string {
+	urls := strings.Fields(val)
+	for i, rawurl := range urls {
+		urls[i] = redactURL(rawurl)
+	}
+	return strings.Join(urls, " ")
+}
+
+// String returns the volume definition without secrets, so volumes can be
+// logged.
+func (v *webdavfsVolume) String() string {
+	safe := *v
+	for _, secret := range []*string{
+		&safe.Password, &safe.EncryptPassword, &safe.BearerToken, &safe.ShareToken,
+		&safe.FedAuth, &safe.RtFa, &safe.OAuth2ClientSecret, &safe.Headers, &safe.ConfContent,
+		&safe.ClientKey,
+	} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	// volumes created by older versions kept the share token as username
+	if v.ShareToken != "" && v.Username != "" {
+		safe.Username = redacted
+	}
+	safe.URL = redactURL(v.URL)
+	safe.Mirrors = nil
+	for _, mirror := range v.Mirrors {
+		safe.Mirrors = append(safe.Mirrors, redactURL(mirror))
+	}
+	safe.Proxy = redactURL(v.Proxy)
+	safe.CreateOptions = redactOptions(v.CreateOptions)
+
+	data, err := json.Marshal(&safe)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type secretsEntry struct {
+	prefix   string
+	username string
+	password string
+}
+
+// secretsFields splits a line of a davfs2 style secrets file into fields,
+// honouring double quotes and backslash escapes.
+func secretsFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	inField, quoted, escaped := false, false, false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			inField, escaped = true, true
+		case r == '"':
+			inField, quoted = true, !quoted
+		case !quoted && r == '#':
+			// comment until end of line
+			if inField {
+				fields = append(fields, field.String())
+			}
+			return fields
+		case !quoted && (r == ' ' || r == '\t'):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// readSecretsFile reads a davfs2 style secrets file mapping url prefixes or
+// host names to credentials, one "<url> <username> <password>" per line.
+func readSecretsFile(path string) ([]secretsEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []secretsEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := secretsFields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		e := secretsEntry{prefix: fields[0], username: fields[1]}
+		if len(fields) > 2 {
+			e.password = fields[2]
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// hasURLPrefix reports whether target is the url prefix or below it, so
+// https://example.com doesn't match https://example.com.evil.org/ and
+// /dav doesn't match /dav2.
+func hasURLPrefix(target, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	target = strings.TrimSuffix(target, "/")
+	return target == prefix || strings.HasPrefix(target, prefix+"/")
+}
+
+// lookupSecrets returns the credentials of the longest entry matching the
+// url, either by url prefix or by host name.
+func lookupSecrets(entries []secretsEntry, u *url.URL) (string, string, bool) {
+	target := u.Scheme + "://" + u.Host + u.Path
+
+	var match *secretsEntry
+	for i, e := range entries {
+		if e.prefix != u.Host && (!strings.Contains(e.prefix, "://") || !hasURLPrefix(target, e.prefix)) {
+			continue
+		}
+		if match == nil || len(e.prefix) > len(match.prefix) {
+			match = &entries[i]
+		}
+	}
+	if match == nil {
+		return "", "", false
+	}
+	return match.username, match.password, true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestSecretsFields(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"# comment", nil},
+		{"https://example.com/dav user secret", []string{"https://example.com/dav", "user", "secret"}},
+		{"  example.com\tuser  secret  ", []string{"example.com", "user", "secret"}},
+		{"example.com user secret # comment", []string{"example.com", "user", "secret"}},
+		{"example.com user secret#comment", []string{"example.com", "user", "secret"}},
+		{`example.com "john doe" "pass word"`, []string{"example.com", "john doe", "pass word"}},
+		{`example.com user "pass # word"`, []string{"example.com", "user", "pass # word"}},
+		{`example.com user pass\ word`, []string{"example.com", "user", "pass word"}},
+		{`example.com user pass\#word\"`, []string{"example.com", "user", `pass#word"`}},
+		{`example.com user ""`, []string{"example.com", "user", ""}},
+	}
+	for _, tt := range tests {
+		if got := secretsFields(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("secretsFields(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestLookupSecrets(t *testing.T) {
+	entries := []secretsEntry{
+		{prefix: "example.com", username: "host"},
+		{prefix: "https://example.com/dav", username: "dav"},
+		{prefix: "https://example.com/dav/team/", username: "team"},
+		{prefix: "http://plain.example.com", username: "plain"},
+	}
+	tests := []struct {
+		rawurl string
+		want   string
+		ok     bool
+	}{
+		{"https://example.com/other", "host", true},
+		{"http://example.com/dav", "host", true},
+		{"https://example.com/dav", "dav", true},
+		{"https://example.com/dav/", "dav", true},
+		{"https://example.com/dav/sub", "dav", true},
+		{"https://example.com/dav2", "host", true},
+		{"https://example.com/dav/team", "team", true},
+		{"https://example.com/dav/team/sub", "team", true},
+		{"http://plain.example.com/dav", "plain", true},
+		{"https://plain.example.com/dav", "", false},
+		{"http://plain.example.com.evil.org/dav", "", false},
+		{"https://example.com:8443/dav", "", false},
+		{"https://other.com/dav", "", false},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		username, _, ok := lookupSecrets(entries, u)
+		if username != tt.want || ok != tt.ok {
+			t.Errorf("lookupSecrets(%s) = %q, %v, want %q, %v", tt.rawurl, username, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const sharepointSTS = "https://login.microsoftonline.com/extSTS.srf"
+
+const sharepointTokenRequest = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://www.w3.org/2005/08/addressing" xmlns:u="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">
+  <s:Header>
+    <a:Action s:mustUnderstand="1">http://schemas.xmlsoap.org/ws/2005/02/trust/RST/Issue</a:Action>
+    <a:ReplyTo><a:Address>http://www.w3.org/2005/08/addressing/anonymous</a:Address></a:ReplyTo>
+    <a:To s:mustUnderstand="1">` + sharepointSTS + `</a:To>
+    <o:Security s:mustUnderstand="1" xmlns:o="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">
+      <o:UsernameToken>
+        <o:Username>%s</o:Username>
+        <o:Password>%s</o:Password>
+      </o:UsernameToken>
+    </o:Security>
+  </s:Header>
+  <s:Body>
+    <t:RequestSecurityToken xmlns:t="http://schemas.xmlsoap.org/ws/2005/02/trust">
+      <wsp:AppliesTo xmlns:wsp="http://schemas.xmlsoap.org/ws/2004/09/policy">
+        <a:EndpointReference><a:Address>%s</a:Address></a:EndpointReference>
+      </wsp:AppliesTo>
+      <t:KeyType>http://schemas.xmlsoap.org/ws/2005/05/identity/NoProofKey</t:KeyType>
+      <t:RequestType>http://schemas.xmlsoap.org/ws/2005/02/trust/Issue</t:RequestType>
+      <t:TokenType>urn:oasis:names:tc:SAML:1.0:assertion</t:TokenType>
+    </t:RequestSecurityToken>
+  </s:Body>
+</s:Envelope>`
+
+var sharepointTokenRe = regexp.MustCompile(`<wsse:BinarySecurityToken[^>]*>([^<]+)</wsse:BinarySecurityToken>`)
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// sharepointCookies returns the Cookie header authenticating against
+// SharePoint, either from the cookies given in the volume options or by
+// signing in to SharePoint Online with the volume credentials.
+func sharepointCookies(v *webdavfsVolume) (string, error) {
+	if v.FedAuth != "" {
+		return fmt.Sprintf("FedAuth=%s; rtFa=%s", v.FedAuth, v.RtFa), nil
+	}
+
+	u, err := url.Parse(v.url)
+	if err != nil {
+		return "", err
+	}
+	site := fmt.Sprintf("%s://%s/", u.Scheme, u.Host)
+	username, password := credentials(v, u)
+
+	// exchange the credentials for a security token
+	body := fmt.Sprintf(sharepointTokenRequest, xmlEscape(username), xmlEscape(password), xmlEscape(site))
+	client, err := newClient(v)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Post(sharepointSTS, "application/soap+xml; charset=utf-8", strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	m := sharepointTokenRe.FindSubmatch(data)
+	if m == nil {
+		return "", fmt.Errorf("sharepoint: sign in failed")
+	}
+
+	// exchange the security token for the FedAuth and rtFa cookies
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err = client.Post(site+"_forms/default.aspx?wa=wsignin1.0", "application/x-www-form-urlencoded", bytes.NewReader(m[1]))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	var cookies []string
+	for _, c := range resp.Cookies() {
+		if c.Name == "FedAuth" || c.Name == "rtFa" {
+			cookies = append(cookies, c.Name+"="+c.Value)
+		}
+	}
+	if len(cookies) != 2 {
+		return "", fmt.Errorf("sharepoint: no FedAuth and rtFa cookies returned")
+	}
+	return strings.Join(cookies, "; "), nil
+}
+
+// sharepointRefreshInterval is how often the SharePoint cookies of the sign
+// in are renewed, well before they expire.
+const sharepointRefreshInterval = time.Hour
+
+// refreshSharepointCookies signs in to SharePoint again every
+// sharepointRefreshInterval until the proxy is closed, with the current
+// credentials of the volume.
+func (d *webdavfsDriver) refreshSharepointCookies(v *webdavfsVolume, p *davProxy) {
+	delay := sharepointRefreshInterval
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(delay):
+		}
+
+		d.RLock()
+		c := *v
+		d.RUnlock()
+		cookies, err := sharepointCookies(&c)
+		if err != nil {
+			logrus.WithField("method", "refreshSharepointCookies").Error(err)
+			delay = 30 * time.Second
+			continue
+		}
+		p.setHeader("Cookie", cookies)
+		delay = sharepointRefreshInterval
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// transferStats counts the requests and bytes transferred of a volume
+// mounted through a davProxy.
+type transferStats struct {
+	requests   int64
+	errors     int64
+	uploaded   int64
+	downloaded int64
+}
+
+// statsTransport counts the requests sent through it.
+type statsTransport struct {
+	next  http.RoundTripper
+	stats *transferStats
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+func (t *statsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&t.stats.requests, 1)
+	if r.Body != nil {
+		r.Body = &countingReader{r.Body, &t.stats.uploaded}
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		atomic.AddInt64(&t.stats.errors, 1)
+		return nil, err
+	}
+	if resp.StatusCode >= 500 {
+		atomic.AddInt64(&t.stats.errors, 1)
+	}
+	resp.Body = &countingReader{resp.Body, &t.stats.downloaded}
+	return resp, nil
+}
+
+// status returns the counters for the status of the volume.
+func (s *transferStats) status() map[string]interface{} {
+	return map[string]interface{}{
+		"Requests":        atomic.LoadInt64(&s.requests),
+		"RequestErrors":   atomic.LoadInt64(&s.errors),
+		"BytesUploaded":   atomic.LoadInt64(&s.uploaded),
+		"BytesDownloaded": atomic.LoadInt64(&s.downloaded),
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCiphers returns the supported cipher suites by name.
+func tlsCiphers() map[string]uint16 {
+	ciphers := map[string]uint16{}
+	for _, c := range tls.CipherSuites() {
+		ciphers[c.Name] = c.ID
+	}
+	return ciphers
+}
+
+func isCipherSeparator(r rune) bool {
+	return r == ',' || r == ':'
+}
+
+// readPEM returns the PEM data of an option which is either inline PEM or
+// the path to a PEM file.
+func readPEM(val string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(val), "-----BEGIN") {
+		return []byte(val), nil
+	}
+	return ioutil.ReadFile(val)
+}
+
+// loadClientCert loads and validates the client certificate and key pair.
+func loadClientCert(v *webdavfsVolume) (tls.Certificate, error) {
+	if v.ClientCert == "" || v.ClientKey == "" {
+		return tls.Certificate{}, fmt.Errorf("both certificate and key are required")
+	}
+	cert, err := readPEM(v.ClientCert)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := readPEM(v.ClientKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(cert, key)
+}
+
+// loadCACert returns a pool with the system roots and the volume CA bundle.
+func loadCACert(v *webdavfsVolume) (*x509.CertPool, error) {
+	data, err := readPEM(v.CACert)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return pool, nil
+}
+
+// newTLSConfig returns the TLS configuration the driver uses when talking to
+// the WebDAV server of the volume itself.
+func newTLSConfig(v *webdavfsVolume) (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: v.Insecure}
+	if v.ClientCert != "" {
+		cert, err := loadClientCert(v)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if v.CACert != "" {
+		pool, err := loadCACert(v)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = pool
+	}
+	if v.TLSMinVersion != "" {
+		version, ok := tlsVersions[v.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", v.TLSMinVersion)
+		}
+		c.MinVersion = version
+	}
+	for _, name := range strings.FieldsFunc(v.TLSCiphers, isCipherSeparator) {
+		id, ok := tlsCiphers()[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher %q", name)
+		}
+		c.CipherSuites = append(c.CipherSuites, id)
+	}
+	return c, nil
+}
+
+// writeClientCert converts the client certificate and key into the PKCS#12
+// file davfs2 expects and returns its path.
+func (d *webdavfsDriver) writeClientCert(v *webdavfsVolume) (string, error) {
+	base := filepath.Join(d.confPath, filepath.Base(v.Mountpoint))
+
+	cert, err := readPEM(v.ClientCert)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(base+".crt", cert, 0600); err != nil {
+		return "", err
+	}
+	key, err := readPEM(v.ClientKey)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(base+".key", key, 0600); err != nil {
+		return "", err
+	}
+
+	out, err := exec.Command("openssl", "pkcs12", "-export", "-passout", "pass:",
+		"-in", base+".crt", "-inkey", base+".key", "-out", base+".p12").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("openssl: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return base + ".p12", nil
+}
+
+// writeCACert writes the volume CA bundle for davfs2 and returns its path.
+func (d *webdavfsDriver) writeCACert(v *webdavfsVolume) (string, error) {
+	data, err := readPEM(v.CACert)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(d.confPath, filepath.Base(v.Mountpoint)+".ca.pem")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeServerCert fetches the certificate the server currently presents and
+// writes it for davfs2 to trust, which is how certificate verification is
+// disabled for insecure volumes.
+func (d *webdavfsDriver) writeServerCert(v *webdavfsVolume) (string, error) {
+	logrus.WithField("url", redactURL(v.url)).Warn("TLS certificate verification is disabled for this volume")
+
+	// connected like the server of the volume, through its proxy
+	req, err := http.NewRequest("OPTIONS", v.url, nil)
+	if err != nil {
+		return "", err
+	}
+	client, err := newClient(v)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return "", fmt.Errorf("server presented no certificate")
+	}
+	certs := resp.TLS.PeerCertificates
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certs[0].Raw})
+
+	path := filepath.Join(d.confPath, filepath.Base(v.Mountpoint)+".server.pem")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
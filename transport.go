@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// proxyURL parses the proxy option, which may omit the scheme.
+func proxyURL(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy %q has no host", proxy)
+	}
+	return u, nil
+}
+
+// isSocksProxy reports whether proxy is a SOCKS5 proxy, which davfs2 doesn't
+// support so the driver connects through it instead.
+func isSocksProxy(proxy string) bool {
+	return strings.HasPrefix(proxy, "socks5://") || strings.HasPrefix(proxy, "socks5h://")
+}
+
+// localAddr returns the source address outgoing connections of the volume
+// are bound to, nil when they aren't.
+func localAddr(v *webdavfsVolume) (*net.TCPAddr, error) {
+	if v.BindAddress != "" {
+		ip := net.ParseIP(v.BindAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid bind address %q", v.BindAddress)
+		}
+		return &net.TCPAddr{IP: ip}, nil
+	}
+	if v.BindInterface != "" {
+		iface, err := net.InterfaceByName(v.BindInterface)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+				return &net.TCPAddr{IP: ipnet.IP}, nil
+			}
+		}
+		return nil, fmt.Errorf("interface %s has no usable address", v.BindInterface)
+	}
+	return nil, nil
+}
+
+// newTransport returns the transport the driver uses when talking to the
+// WebDAV server of the volume itself.
+func newTransport(v *webdavfsVolume) (*http.Transport, error) {
+	c, err := newTLSConfig(v)
+	if err != nil {
+		return nil, err
+	}
+	t := &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: c}
+
+	if v.Proxy != "" {
+		u, err := proxyURL(v.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	addr, err := localAddr(v)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if v.ConnectTimeout > 0 {
+		dialer.Timeout = time.Duration(v.ConnectTimeout) * time.Second
+	}
+	if addr != nil {
+		dialer.LocalAddr = addr
+	}
+	network := "tcp"
+	if v.IPVersion == "4" || v.IPVersion == "6" {
+		network += v.IPVersion
+	}
+	t.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	if v.ReadTimeout > 0 {
+		t.ResponseHeaderTimeout = time.Duration(v.ReadTimeout) * time.Second
+	}
+	return t, nil
+}
+
+// newClient returns the client the driver uses when talking to the WebDAV
+// server of the volume itself. Requests time out after connect_timeout and
+// read_timeout together, a minute by default.
+func newClient(v *webdavfsVolume) (*http.Client, error) {
+	t, err := newTransport(v)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t, Timeout: requestTimeout(v)}, nil
+}
+
+// requestTimeout returns how long requests of the driver to the server of the
+// volume may take.
+func requestTimeout(v *webdavfsVolume) time.Duration {
+	if timeout := time.Duration(v.ConnectTimeout+v.ReadTimeout) * time.Second; timeout > 0 {
+		return timeout
+	}
+	return time.Minute
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// trashedVolume is a removed volume kept for the REMOVE_RETENTION period,
+// it can be restored with restore=true until then.
+type trashedVolume struct {
+	Volume    *webdavfsVolume
+	RemovedAt time.Time
+}
+
+func (d *webdavfsDriver) loadTrash() error {
+	data, err := ioutil.ReadFile(d.trashPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &d.trash)
+}
+
+func (d *webdavfsDriver) saveTrash() {
+	data, err := json.Marshal(d.trash)
+	if err != nil {
+		logrus.WithField("trashPath", d.trashPath).Error(err)
+		return
+	}
+
+	if err := ioutil.WriteFile(d.trashPath, data, 0600); err != nil {
+		logrus.WithField("trashPath", d.trashPath).Error(err)
+	}
+}
+
+// restoreVolume moves a removed volume out of the trash again.
+func (d *webdavfsDriver) restoreVolume(name string) error {
+	if _, ok := d.volumes[name]; ok {
+		return fmt.Errorf("volume %s already exists", name)
+	}
+	t, ok := d.trash[name]
+	if !ok {
+		return fmt.Errorf("volume %s not found in trash", name)
+	}
+
+	logrus.WithField("volume", name).Info("restoring volume")
+	d.volumes[name] = t.Volume
+	delete(d.trash, name)
+	d.saveState()
+	d.saveTrash()
+	return nil
+}
+
+// emptyTrash periodically removes the volumes which were removed longer
+// than the retention period ago for good.
+func (d *webdavfsDriver) emptyTrash(interval time.Duration) {
+	for range time.Tick(interval) {
+		d.Lock()
+		for name, t := range d.trash {
+			if time.Since(t.RemovedAt) < d.retention {
+				continue
+			}
+
+			logrus.WithField("volume", name).Info("retention period over, removing volume")
+			if err := d.destroyVolume(t.Volume); err != nil {
+				logrus.WithField("volume", name).Error(err)
+				continue
+			}
+			delete(d.trash, name)
+			d.saveTrash()
+		}
+		d.Unlock()
+	}
+}
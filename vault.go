@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// readVaultSecret reads a vault://path#field reference from the Vault server
+// configured with the VAULT_ADDR and VAULT_TOKEN environment variables. Both
+// KV version 1 and 2 secret engines are supported.
+func readVaultSecret(ref string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("vault: VAULT_ADDR not set")
+	}
+
+	path := strings.TrimPrefix(ref, "vault://")
+	field := "value"
+	if i := strings.Index(path, "#"); i >= 0 {
+		path, field = path[:i], path[i+1:]
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault: reading %s returned %s", path, resp.Status)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", err
+	}
+
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	val, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault: field %q not found in %s", field, path)
+	}
+	return val, nil
+}
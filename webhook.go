@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// notifyMountFailure posts the failed mount of the volume to the webhook
+// in the background.
+func (d *webdavfsDriver) notifyMountFailure(name string, v *webdavfsVolume, mountErr error) {
+	if d.webhook == "" {
+		return
+	}
+	hostname, _ := os.Hostname()
+	data, _ := json.Marshal(map[string]interface{}{
+		"event":  "mount_failed",
+		"time":   time.Now().UTC().Format(time.RFC3339),
+		"host":   hostname,
+		"volume": name,
+		"url":    redactURL(v.URL),
+		"error":  mountErr.Error(),
+	})
+
+	go func() {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(d.webhook, "application/json", bytes.NewReader(data))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = fmt.Errorf("returned %s", resp.Status)
+			}
+		}
+		if err != nil {
+			logrus.WithField("method", "webhook").Warn(err)
+		}
+	}()
+}
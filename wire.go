@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// redactedHeaders are logged without their value.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// wireTransport logs the requests and responses of a volume.
+type wireTransport struct {
+	next http.RoundTripper
+	log  *log.Logger
+	// redactedHeaders and the headers option of the volume, which often
+	// carries API keys
+	redacted map[string]bool
+}
+
+func newWireTransport(next http.RoundTripper, w io.Writer, v *webdavfsVolume) (*wireTransport, error) {
+	headers, err := parseHeaders(v.Headers)
+	if err != nil {
+		return nil, err
+	}
+	t := &wireTransport{next: next, log: log.New(w, "", log.LstdFlags|log.Lmicroseconds), redacted: map[string]bool{}}
+	for key := range redactedHeaders {
+		t.redacted[key] = true
+	}
+	for _, h := range headers {
+		t.redacted[http.CanonicalHeaderKey(h[0])] = true
+	}
+	return t, nil
+}
+
+func (t *wireTransport) logHeaders(prefix string, header http.Header) {
+	var keys []string
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, val := range header[key] {
+			if t.redacted[key] {
+				val = redacted
+			}
+			t.log.Printf("%s %s: %s", prefix, key, val)
+		}
+	}
+}
+
+func (t *wireTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	t.log.Printf("> %s %s", r.Method, r.URL.RequestURI())
+	t.logHeaders(">", r.Header)
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		t.log.Printf("< %v (%v)", err, time.Since(start))
+		return nil, err
+	}
+	t.log.Printf("< %s (%v)", resp.Status, time.Since(start))
+	t.logHeaders("<", resp.Header)
+	return resp, nil
+}
+
+// openWireLog opens the file the requests and responses of the volume are
+// logged to.
+func (d *webdavfsDriver) openWireLog(v *webdavfsVolume) (*os.File, error) {
+	if err := os.MkdirAll(d.logPath, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(d.logPath, filepath.Base(v.Mountpoint)+".http.log")
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}